@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// jobTargets is the job setting that stands for targets, which are arguments rather than flags.
+const jobTargets = `targets`
+
+// jobSchedule is the job setting with a cron expression, which tells the daemon when to run the job.
+const jobSchedule = `schedule`
+
+// jobConfig is a YAML file of named jobs. Each job sets flags of the pack command by
+// their long names, along with the targets to pack:
+//
+//	jobs:
+//	  nightly:
+//	    targets: [/srv/www, /etc]
+//	    key: MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQ...
+//	    exclude: [cache/]
+//	    output: /backups/{host}-{year}-{month}-{day}-{hash}.sane1
+//	    upload: [s3://id:secret@us-east-1/bucket/backups/]
+//	    keep-daily: 7
+//	    keep-weekly: 4
+//	    schedule: 30 2 * * *
+type jobConfig struct {
+	path string
+	jobs map[string]*yaml.Node
+}
+
+// defaultConfigPath is the configuration file used unless another is given.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return `sane-archiver.yaml`
+	}
+	return filepath.Join(dir, `sane-archiver`, `jobs.yaml`)
+}
+
+// errorf points at the line of the offending node.
+func (c *jobConfig) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf(`%s:%d: %s`, c.path, node.Line, fmt.Sprintf(format, args...))
+}
+
+func loadJobConfig(path string) (*jobConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(`could not read job configuration: %w`, err)
+	}
+	c := &jobConfig{path: path, jobs: make(map[string]*yaml.Node)}
+	document := &yaml.Node{}
+	if err = yaml.Unmarshal(b, document); err != nil {
+		return nil, fmt.Errorf(`%s: %w`, path, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, fmt.Errorf(`%s: no jobs are defined`, path)
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, c.errorf(root, `expected a mapping with jobs`)
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != `jobs` {
+			return nil, c.errorf(key, `unknown section %q, jobs are defined under "jobs"`, key.Value)
+		}
+		if value.Kind != yaml.MappingNode {
+			return nil, c.errorf(value, `expected a mapping of job names to settings`)
+		}
+		for j := 0; j < len(value.Content); j += 2 {
+			name, job := value.Content[j], value.Content[j+1]
+			if job.Kind != yaml.MappingNode {
+				return nil, c.errorf(job, `settings of job %q must be a mapping`, name.Value)
+			}
+			if _, ok := c.jobs[name.Value]; ok {
+				return nil, c.errorf(name, `job %q is defined twice`, name.Value)
+			}
+			c.jobs[name.Value] = job
+		}
+	}
+	return c, nil
+}
+
+// jobSettings are the values of a job, ready to be given to command line flags.
+type jobSettings struct {
+	targets []string
+	flags   map[string]interface{}
+}
+
+// job checks settings of the named job against flags of the command. Values are parsed
+// the same way as on the command line, so that mistakes are reported with their line.
+func (c *jobConfig) job(name string, flags []*kong.Flag) (*jobSettings, error) {
+	job, ok := c.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf(`%s: job %q is not defined, choose one of: %s`, c.path, name, strings.Join(c.names(), `, `))
+	}
+	known := make(map[string]*kong.Flag, len(flags))
+	for _, flag := range flags {
+		known[flag.Name] = flag
+	}
+	s := &jobSettings{flags: make(map[string]interface{})}
+	for i := 0; i < len(job.Content); i += 2 {
+		key, node := job.Content[i], job.Content[i+1]
+		value, err := c.value(key.Value, node)
+		if err != nil {
+			return nil, err
+		}
+		if key.Value == jobTargets {
+			for _, target := range value.([]interface{}) {
+				s.targets = append(s.targets, target.(string))
+			}
+			continue
+		}
+		if key.Value == jobSchedule {
+			continue // checked by schedule
+		}
+		flag, ok := known[key.Value]
+		if !ok || key.Value == `help` || key.Value == `version` || key.Value == `config` {
+			return nil, c.errorf(key, `job %q has unknown setting %q`, name, key.Value)
+		}
+		if _, ok := value.([]interface{}); ok && !flag.IsSlice() {
+			return nil, c.errorf(node, `setting %q takes a single value, not a list`, key.Value)
+		}
+		if text, ok := value.(string); ok && flag.Enum != `` && !flag.EnumMap()[text] {
+			return nil, c.errorf(node, `setting %q must be one of %s, not %q`, key.Value, flag.Enum, text)
+		}
+		scratch := reflect.New(flag.Target.Type()).Elem()
+		if err = flag.Parse(kong.Scan().PushTyped(value, kong.FlagValueToken), scratch); err != nil {
+			return nil, c.errorf(node, `setting %q: %s`, key.Value, err)
+		}
+		s.flags[key.Value] = value
+	}
+	return s, nil
+}
+
+// names lists jobs in alphabetical order.
+func (c *jobConfig) names() []string {
+	names := make([]string, 0, len(c.jobs))
+	for name := range c.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// schedule reads the cron expression of the job, which is nil for jobs that are only run by hand.
+func (c *jobConfig) schedule(name string) (*cronSchedule, error) {
+	job := c.jobs[name]
+	for i := 0; i < len(job.Content); i += 2 {
+		if key, node := job.Content[i], job.Content[i+1]; key.Value == jobSchedule {
+			if node.Kind != yaml.ScalarNode {
+				return nil, c.errorf(node, `schedule of job %q must be a cron expression`, name)
+			}
+			schedule, err := parseCron(node.Value)
+			if err != nil {
+				return nil, c.errorf(node, `%s`, err)
+			}
+			return schedule, nil
+		}
+	}
+	return nil, nil
+}
+
+// value turns a setting into a string, or a list of strings for sequences.
+func (c *jobConfig) value(name string, node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if name == jobTargets {
+			return []interface{}{node.Value}, nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		l := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, c.errorf(item, `setting %q must be a list of plain values`, name)
+			}
+			l = append(l, item.Value)
+		}
+		return l, nil
+	}
+	return nil, c.errorf(node, `setting %q must be a value or a list of values`, name)
+}
+
+// Validate lets the job settings serve as a kong resolver, but they were checked when loaded.
+func (s *jobSettings) Validate(app *kong.Application) error {
+	return nil
+}
+
+// Resolve supplies values of flags that were not given on the command line.
+func (s *jobSettings) Resolve(context *kong.Context, parent *kong.Path, flag *kong.Flag) (interface{}, error) {
+	if value, ok := s.flags[flag.Name]; ok {
+		return value, nil
+	}
+	return nil, nil
+}
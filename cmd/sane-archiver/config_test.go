@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+const testJobs = `jobs:
+  nightly:
+    targets: [/srv/www, /etc]
+    key: KEY
+    format: tar
+    exclude:
+      - cache/
+      - '*.tmp'
+    keep-daily: 7
+  typo:
+    exlude: cache/
+  wrong:
+    keep-daily: 7
+    format: rar
+`
+
+func TestJobConfig(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-config-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, `jobs.yaml`)
+	if err = ioutil.WriteFile(config, []byte(testJobs), 0600); err != nil {
+		t.Fatal(err)
+	}
+	parse := func(args ...string) (*runTask, error) {
+		var cli struct {
+			Run runTask `kong:"cmd"`
+		}
+		parser, err := kong.New(&cli)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parser.Parse(append([]string{`run`, `--config`, config}, args...))
+		return &cli.Run, err
+	}
+
+	job, err := parse(`nightly`, `--keep-daily`, `3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Key != `KEY` || job.Format != `tar` || job.Daily != 3 ||
+		!reflect.DeepEqual(job.Exclude, []string{`cache/`, `*.tmp`}) ||
+		!reflect.DeepEqual(job.settings.targets, []string{`/srv/www`, `/etc`}) {
+		t.Fatalf(`job settings were not applied, or flags did not override them: %+v`, job.packTask)
+	}
+	if job.Compress != `deflate` {
+		t.Fatalf(`default of an unset flag was lost: %q`, job.Compress)
+	}
+
+	for args, line := range map[string]string{`typo`: `:11:`, `wrong`: `:14:`, `missing`: `not defined`} {
+		if _, err = parse(args); err == nil || !strings.Contains(err.Error(), line) {
+			t.Fatalf(`job %s should fail with %q, but got: %v`, args, line, err)
+		}
+	}
+}
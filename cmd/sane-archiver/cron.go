@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are shorthands of common schedules.
+var cronMacros = map[string]string{
+	`@yearly`:   `0 0 1 1 *`,
+	`@annually`: `0 0 1 1 *`,
+	`@monthly`:  `0 0 1 * *`,
+	`@weekly`:   `0 0 * * 0`,
+	`@daily`:    `0 0 * * *`,
+	`@midnight`: `0 0 * * *`,
+	`@hourly`:   `0 * * * *`,
+}
+
+var cronMonths = []string{`jan`, `feb`, `mar`, `apr`, `may`, `jun`, `jul`, `aug`, `sep`, `oct`, `nov`, `dec`}
+var cronWeekdays = []string{`sun`, `mon`, `tue`, `wed`, `thu`, `fri`, `sat`}
+
+// cronField is the set of values allowed in one field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // Names of values, starting from the minimum.
+}
+
+var cronFields = []cronField{
+	{name: `minute`, min: 0, max: 59},
+	{name: `hour`, min: 0, max: 23},
+	{name: `day of month`, min: 1, max: 31},
+	{name: `month`, min: 1, max: 12, names: cronMonths},
+	{name: `day of week`, min: 0, max: 7, names: cronWeekdays}, // both 0 and 7 are Sunday
+}
+
+// cronSchedule is a parsed cron expression: minute, hour, day of month, month and day of week.
+// As in cron, a day matches if either of its fields does, when both are restricted.
+type cronSchedule struct {
+	expression string
+	minute     [60]bool
+	hour       [24]bool
+	day        [32]bool
+	month      [13]bool
+	weekday    [7]bool
+	anyDay     bool // day of month is *
+	anyWeekday bool // day of week is *
+}
+
+// parseCron reads expressions such as "30 2 * * mon-fri", "*/15 * * * *" or "@daily".
+func parseCron(expression string) (*cronSchedule, error) {
+	s := &cronSchedule{expression: expression}
+	if macro, ok := cronMacros[strings.ToLower(expression)]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf(`cron expression %q must have %d fields: minute, hour, day of month, month and day of week`, s.expression, len(cronFields))
+	}
+	targets := [][]bool{s.minute[:], s.hour[:], s.day[:], s.month[:], make([]bool, 8)}
+	for i, field := range fields {
+		if err := cronFields[i].parse(field, targets[i]); err != nil {
+			return nil, fmt.Errorf(`cron expression %q: %w`, s.expression, err)
+		}
+	}
+	copy(s.weekday[:], targets[4])
+	s.weekday[0] = s.weekday[0] || targets[4][7]
+	s.anyDay, s.anyWeekday = fields[2] == `*`, fields[4] == `*`
+	return s, nil
+}
+
+// parse marks values of a comma-separated list of values, ranges and steps.
+func (f cronField) parse(text string, allowed []bool) error {
+	for _, part := range strings.Split(text, `,`) {
+		step := 1
+		if at := strings.Index(part, `/`); at >= 0 {
+			n, err := strconv.Atoi(part[at+1:])
+			if err != nil || n < 1 {
+				return fmt.Errorf(`%s step %q is not a positive number`, f.name, part[at+1:])
+			}
+			step, part = n, part[:at]
+		}
+		from, to := f.min, f.max
+		if part != `*` {
+			bounds := strings.SplitN(part, `-`, 2)
+			var err error
+			if from, err = f.value(bounds[0]); err != nil {
+				return err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = f.value(bounds[1]); err != nil {
+					return err
+				}
+			} else if step > 1 {
+				to = f.max // 5/15 means from 5 onwards
+			}
+			if to < from {
+				return fmt.Errorf(`%s range %q is reversed`, f.name, part)
+			}
+		}
+		for v := from; v <= to; v += step {
+			allowed[v] = true
+		}
+	}
+	return nil
+}
+
+func (f cronField) value(text string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(text, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf(`%s %q is not between %d and %d`, f.name, text, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day, weekday := s.day[t.Day()], s.weekday[t.Weekday()]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next is the first time matching the schedule after t, or zero if nothing matches within five years.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) String() string {
+	return s.expression
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	// a Monday
+	from := time.Date(2026, time.October, 19, 10, 17, 30, 0, time.Local)
+	cases := []struct {
+		expression string
+		next       time.Time
+	}{
+		{`* * * * *`, time.Date(2026, time.October, 19, 10, 18, 0, 0, time.Local)},
+		{`*/15 * * * *`, time.Date(2026, time.October, 19, 10, 30, 0, 0, time.Local)},
+		{`30 2 * * *`, time.Date(2026, time.October, 20, 2, 30, 0, 0, time.Local)},
+		{`@daily`, time.Date(2026, time.October, 20, 0, 0, 0, 0, time.Local)},
+		{`0 12 * * sat,sun`, time.Date(2026, time.October, 24, 12, 0, 0, 0, time.Local)},
+		{`0 0 * * 7`, time.Date(2026, time.October, 25, 0, 0, 0, 0, time.Local)},
+		{`0 9-17/4 * * mon-fri`, time.Date(2026, time.October, 19, 13, 0, 0, 0, time.Local)},
+		{`0 0 1 jan *`, time.Date(2027, time.January, 1, 0, 0, 0, 0, time.Local)},
+		{`0 0 29 2 *`, time.Date(2028, time.February, 29, 0, 0, 0, 0, time.Local)},
+		// either day field matches when both are given
+		{`0 0 1 * fri`, time.Date(2026, time.October, 23, 0, 0, 0, 0, time.Local)},
+		{`0 0 31 2 *`, time.Time{}},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.expression)
+		if err != nil {
+			t.Fatalf(`%s: %s`, c.expression, err)
+		}
+		if next := s.Next(from); !next.Equal(c.next) {
+			t.Errorf(`%s runs next at %s instead of %s`, c.expression, next, c.next)
+		}
+	}
+	for _, expression := range []string{``, `* * * *`, `60 * * * *`, `* * 0 * *`, `5-1 * * * *`, `*/0 * * * *`, `* * * foo *`} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf(`%q was accepted`, expression)
+		}
+	}
+}
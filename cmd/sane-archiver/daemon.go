@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+type daemonTask struct {
+	Config  string `kong:"flag,name='config',short='c',env='SaneArchiverConfig',help='Job configuration file, ~/.config/sane-archiver/jobs.yaml by default.'"`
+	Retries int    `kong:"flag,name='upload-retries',default='3',env='SaneArchiverUploadRetries',help='Retry failed uploads this many times, unless a job sets upload-retries itself.'"`
+}
+
+// scheduledJob is a job with a schedule, which runs at most once at a time.
+type scheduledJob struct {
+	name     string
+	schedule *cronSchedule
+	next     time.Time
+	running  bool
+}
+
+// configPath is the job configuration file given by flag or environment, or the default one.
+func configPath(config string) string {
+	if config == `` {
+		return defaultConfigPath()
+	}
+	return kong.ExpandPath(config)
+}
+
+func (t *daemonTask) Run(ctx *kong.Context) error {
+	config := configPath(t.Config)
+	c, err := loadJobConfig(config)
+	if err != nil {
+		return err
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	jobs := make(map[string]*scheduledJob)
+	for _, name := range c.names() {
+		schedule, err := c.schedule(name)
+		if err != nil {
+			return err
+		}
+		if schedule == nil {
+			continue
+		}
+		j := &scheduledJob{name: name, schedule: schedule, next: schedule.Next(now)}
+		if j.next.IsZero() {
+			return fmt.Errorf(`schedule %q of job %q never comes`, schedule, name)
+		}
+		slog.Info(`Job is scheduled`, `job`, name, `schedule`, schedule.String(), `next`, j.next)
+		jobs[name] = j
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf(`none of the jobs in %s has a schedule`, config)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	finished := make(chan jobResult)
+	running := 0
+	record := func(r jobResult) {
+		level := slog.LevelInfo
+		if r.Status != jobSucceeded {
+			level = slog.LevelWarn
+		}
+		attrs := []interface{}{`job`, r.Job, `status`, r.Status, `duration`, r.Finished.Sub(r.Started).Round(time.Second).String()}
+		if r.Error != `` {
+			attrs = append(attrs, `error`, r.Error)
+		}
+		if r.Log != `` {
+			attrs = append(attrs, `log`, r.Log)
+		}
+		slog.Log(context.Background(), level, `Job finished`, attrs...)
+		if err := appendHistory(dir, r); err != nil {
+			slog.Warn(`Job result could not be recorded`, `job`, r.Job, `error`, err)
+		}
+	}
+	for {
+		var next time.Time
+		for _, j := range jobs {
+			if next.IsZero() || j.next.Before(next) {
+				next = j.next
+			}
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			now := time.Now()
+			for _, j := range jobs {
+				if j.next.After(now) {
+					continue
+				}
+				j.next = j.schedule.Next(now)
+				if j.running {
+					record(jobResult{Job: j.name, Started: now, Finished: now, Status: jobSkipped,
+						Error: `the previous run has not finished yet`})
+					continue
+				}
+				j.running = true
+				running++
+				go func(name string) { finished <- t.runJob(config, dir, name) }(j.name)
+			}
+		case r := <-finished:
+			timer.Stop()
+			jobs[r.Job].running = false
+			running--
+			record(r)
+		case s := <-signals:
+			timer.Stop()
+			slog.Info(`Waiting for running jobs to finish`, `signal`, s.String(), `running`, running)
+			for ; running > 0; running-- {
+				record(<-finished)
+			}
+			return nil
+		}
+	}
+}
+
+// runJob packs the job in a separate process, so that its output is kept in a log of its own.
+func (t *daemonTask) runJob(config string, dir string, name string) (r jobResult) {
+	r = jobResult{Job: name, Started: time.Now(), Status: jobFailed}
+	defer func() { r.Finished = time.Now() }()
+	exe, err := os.Executable()
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	logs := filepath.Join(dir, `logs`)
+	if err = os.MkdirAll(logs, 0700); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Log = filepath.Join(logs, fmt.Sprintf(`%s-%s.log`,
+		unsafeNameCharacters.ReplaceAllString(name, `_`), r.Started.Format(`20060102-150405`)))
+	out, err := os.Create(r.Log)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer out.Close()
+	cmd := exec.Command(exe, append(append([]string{`run`, `--scheduled`}, CLI.logFlags.args()...), `--config`, config, name)...)
+	cmd.Stdout, cmd.Stderr = out, out
+	// jobs setting upload-retries take precedence over the environment
+	cmd.Env = append(os.Environ(), fmt.Sprintf(`SaneArchiverUploadRetries=%d`, t.Retries))
+	err = cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		r.Status = jobSucceeded
+	case errors.As(err, &exit) && exit.ExitCode() == ExitWarnings:
+		r.Status = jobWarnings
+	case errors.As(err, &exit) && exit.ExitCode() == ExitBusy:
+		r.Status, r.Error = jobSkipped, errJobRunning.Error()
+	default:
+		r.Error = lastLine(r.Log, err)
+	}
+	return r
+}
+
+// logLine matches a text log line: the date and time that the standard logger writes,
+// the level of the record, its message and an error attribute, if it is the last one.
+var logLine = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} (?:[A-Z]+ )?(.*?)(?: error=(.*))?$`)
+
+// lastLine finds the reason a job failed at the end of its log, written as text or as JSON.
+func lastLine(path string, fallback error) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fallback.Error()
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	last := lines[len(lines)-1]
+	record := struct {
+		Message string `json:"msg"`
+		Error   string `json:"error"`
+	}{}
+	if json.Unmarshal(last, &record) != nil {
+		m := logLine.FindStringSubmatch(string(last))
+		if m == nil {
+			return fallback.Error()
+		}
+		record.Message, record.Error = m[1], m[2]
+		if unquoted, err := strconv.Unquote(record.Error); err == nil {
+			record.Error = unquoted
+		}
+	}
+	if record.Error != `` {
+		return record.Error
+	} else if record.Message != `` {
+		return record.Message
+	}
+	return fallback.Error()
+}
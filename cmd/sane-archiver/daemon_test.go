@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLastLine(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-logs-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fallback := errors.New(`exit status 1`)
+
+	for log, expected := range map[string]string{
+		"2026/10/19 11:54:36 INFO Progress stage=packing\n2026/10/19 11:54:36 ERROR Archiver failed error=\"could not pack /src: denied\"\n": `could not pack /src: denied`,
+		"2026/10/19 11:54:36 ERROR Archiver failed error=denied\n":                                                                           `denied`,
+		"2026/10/19 11:54:36 ERROR Operation cancelled\n":                                                                                    `Operation cancelled`,
+		`{"time":"2026-10-19T11:54:36Z","level":"ERROR","msg":"Archiver failed","error":"could not pack /src: denied"}` + "\n":               `could not pack /src: denied`,
+		`{"time":"2026-10-19T11:54:36Z","level":"ERROR","msg":"Operation cancelled"}`:                                                        `Operation cancelled`,
+		"panic: out of memory\n": `exit status 1`,
+		"":                       `exit status 1`,
+	} {
+		p := filepath.Join(dir, `job.log`)
+		if err = ioutil.WriteFile(p, []byte(log), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if reason := lastLine(p, fallback); reason != expected {
+			t.Errorf(`expected %q from log %q, got %q`, expected, log, reason)
+		}
+	}
+	if reason := lastLine(filepath.Join(dir, `missing.log`), fallback); reason != fallback.Error() {
+		t.Errorf(`expected the fallback for a missing log, got %q`, reason)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// historyLimit is the number of job results kept, along with their logs.
+const historyLimit = 1000
+
+// errJobRunning indicates that another run of the same job has not finished yet.
+var errJobRunning = errors.New(`job is already running`)
+
+// Results of job runs.
+const (
+	jobSucceeded = `succeeded`
+	jobWarnings  = `warnings`
+	jobFailed    = `failed`
+	jobSkipped   = `skipped`
+)
+
+// jobResult is a finished run of a job, as recorded in the history.
+type jobResult struct {
+	Job      string    `json:"job"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Log      string    `json:"log,omitempty"` // Output of the run.
+}
+
+// jobStatus tells the result of a run that returned the error, as main tells the exit code.
+func jobStatus(err error) (status string, reason string) {
+	switch {
+	case err == nil:
+		return jobSucceeded, ``
+	case errors.Is(err, errCompletedWithWarnings):
+		return jobWarnings, ``
+	case errors.Is(err, errJobRunning):
+		return jobSkipped, errJobRunning.Error()
+	}
+	return jobFailed, err.Error()
+}
+
+// stateDir holds job locks, the history and logs of job runs.
+func stateDir() (string, error) {
+	dir := os.Getenv(`SaneArchiverState`)
+	if dir == `` {
+		dir = os.Getenv(`XDG_STATE_HOME`)
+		if dir == `` {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return ``, err
+			}
+			dir = filepath.Join(home, `.local`, `state`)
+		}
+		dir = filepath.Join(dir, `sane-archiver`)
+	}
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// lockJob makes sure that only one run of the job is active, even across processes.
+// Closing the returned file releases the lock.
+func lockJob(dir string, name string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, unsafeNameCharacters.ReplaceAllString(name, `_`)+`.lock`), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf(`%q: %w`, name, errJobRunning)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// readHistory loads job results from the oldest.
+func readHistory(dir string) ([]jobResult, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, `history.jsonl`))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	l := make([]jobResult, 0)
+	lines := bufio.NewScanner(bytes.NewReader(b))
+	for lines.Scan() {
+		r := jobResult{}
+		if err = json.Unmarshal(lines.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf(`job history is damaged: %w`, err)
+		}
+		l = append(l, r)
+	}
+	return l, lines.Err()
+}
+
+// appendHistory records a job result. Once the history grows past its limit,
+// the oldest results are dropped along with their logs.
+func appendHistory(dir string, r jobResult) error {
+	l, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	l = append(l, r)
+	if len(l) > historyLimit {
+		for _, dropped := range l[:len(l)-historyLimit] {
+			if dropped.Log != `` {
+				os.Remove(dropped.Log)
+			}
+		}
+		l = l[len(l)-historyLimit:]
+	}
+	b := &bytes.Buffer{}
+	out := json.NewEncoder(b)
+	for _, r := range l {
+		if err = out.Encode(r); err != nil {
+			return err
+		}
+	}
+	p := filepath.Join(dir, `history.jsonl`)
+	if err = ioutil.WriteFile(p+`.tmp`, b.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+`.tmp`, p)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJobHistory(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-state-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := lockJob(dir, `nightly`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lockJob(dir, `nightly`); !errors.Is(err, errJobRunning) {
+		t.Fatalf(`overlapping run was allowed: %v`, err)
+	}
+	lock.Close()
+	if lock, err = lockJob(dir, `nightly`); err != nil {
+		t.Fatalf(`lock was not released: %s`, err)
+	}
+	lock.Close()
+
+	started := time.Now().Round(time.Second)
+	for i := 0; i < historyLimit+5; i++ {
+		r := jobResult{Job: `nightly`, Started: started.Add(time.Duration(i) * time.Minute), Status: jobSucceeded}
+		if err = appendHistory(dir, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := readHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != historyLimit || !l[len(l)-1].Started.Equal(started.Add((historyLimit+4)*time.Minute)) {
+		t.Fatalf(`history kept %d results, the last one started at %s`, len(l), l[len(l)-1].Started)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-state-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv(`SaneArchiverState`, dir)
+
+	lock, err := lockJob(dir, `nightly`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	for _, scheduled := range []bool{false, true} {
+		task := &runTask{Job: `nightly`, Scheduled: scheduled}
+		if err = task.Run(nil); !errors.Is(err, errJobRunning) {
+			t.Fatalf(`overlapping run was allowed: %v`, err)
+		}
+	}
+	l, err := readHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// runs started by the scheduler are recorded by the scheduler
+	if len(l) != 1 || l[0].Job != `nightly` || l[0].Status != jobSkipped || l[0].Finished.IsZero() {
+		t.Errorf(`expected a skipped run to be recorded, got %+v`, l)
+	}
+	if status, _ := jobStatus(fmt.Errorf(`pack: %w`, errCompletedWithWarnings)); status != jobWarnings {
+		t.Errorf(`expected warnings, got %s`, status)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archiver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/alecthomas/kong"
+)
+
+type infoTask struct {
+	Key  string   `kong:"flag,help='Private base64-encoded key.'"`
+	JSON bool     `kong:"flag,name='json',help='Print the manifest as it is stored.'"`
+	File []string `kong:"arg,required,help='File or s3:// URL to describe.',sep=' '"`
+}
+
+var errNoManifest = errors.New(`archive has no manifest, it was made by an older version of the archiver`)
+
+// readManifest finds the manifest among archive entries.
+func readManifest(target string, key string) (*archiver.Manifest, error) {
+	r, size, in, err := openArchive(target, key)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	var m *archiver.Manifest
+	_, err = readEntries(r, size, func(e archiveEntry) error {
+		if e.Name != archiver.ManifestName {
+			return nil
+		}
+		f, err := e.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		m, err = archiver.ReadManifest(f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errNoManifest
+	}
+	return m, nil
+}
+
+func printManifest(m *archiver.Manifest) error {
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(out, "Version:\t%s\n", m.Version)
+	fmt.Fprintf(out, "Host:\t%s\n", m.Host)
+	fmt.Fprintf(out, "Created:\t%s\n", m.Created.Local().Format(`2006-01-02 15:04:05 MST`))
+	for _, target := range m.Targets {
+		fmt.Fprintf(out, "Target:\t%s\n", target)
+	}
+	for _, source := range m.Sources {
+		fmt.Fprintf(out, "Command output:\t%s\n", source)
+	}
+	fmt.Fprintf(out, "Files:\t%d, %d bytes\n", len(m.Files), size)
+	for _, ref := range m.Git {
+		fmt.Fprintf(out, "Git:\t%s %s at %s\n", ref.Repository, ref.Reference, ref.Commit)
+	}
+	for _, warning := range m.Warnings {
+		fmt.Fprintf(out, "Warning:\t%s\n", warning)
+	}
+	return out.Flush()
+}
+
+func (c *infoTask) Run(ctx *kong.Context) error {
+	if c.Key == "" {
+		key, err := ReadKey(`Please enter private key (-k) to describe target archives:`)
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+	for i, arg := range c.File {
+		m, err := readManifest(arg, c.Key)
+		if err != nil {
+			return fmt.Errorf("could not describe file <%s>: %w", displayTarget(arg), err)
+		}
+		if c.JSON {
+			out := json.NewEncoder(os.Stdout)
+			out.SetIndent(``, `  `)
+			if err = out.Encode(m); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(c.File) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", displayTarget(arg))
+		}
+		if err = printManifest(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// logFlags apply to every command.
+type logFlags struct {
+	LogFormat string `kong:"flag,name='log-format',enum='text,json',default='text',env='SaneArchiverLogFormat',help='Write the log to stderr as text, or as JSON lines for other programs to read.'"`
+	Quiet     bool   `kong:"flag,name='quiet',short='q',help='Log only warnings and errors.'"`
+	Verbose   bool   `kong:"flag,name='verbose',help='Also log every file and how git branches were selected.'"`
+}
+
+// switchWriter forwards writes to a writer that can be replaced at any time.
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *switchWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	w := s.w
+	s.mu.Unlock()
+	return w.Write(b)
+}
+
+// Set replaces the writer.
+func (s *switchWriter) Set(w io.Writer) {
+	s.mu.Lock()
+	s.w = w
+	s.mu.Unlock()
+}
+
+// logOutput is where the log goes in either format. The progress bar takes it over,
+// so that log lines are printed above the bar.
+var logOutput = &switchWriter{w: os.Stderr}
+
+// logJSON is set when the log is written as JSON, which a progress bar would spoil.
+var logJSON bool
+
+func (f logFlags) level() slog.Level {
+	switch {
+	case f.Quiet:
+		return slog.LevelWarn
+	case f.Verbose:
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// setup configures the default logger, which the archiver library logs through.
+func (f logFlags) setup() error {
+	if f.Quiet && f.Verbose {
+		return fmt.Errorf(`--quiet and --verbose cannot be used together`)
+	}
+	if f.LogFormat == `json` {
+		logJSON = true
+		// plain log calls are routed into the handler as well
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, &slog.HandlerOptions{Level: f.level()})))
+	} else {
+		slog.SetLogLoggerLevel(f.level())
+	}
+	return nil
+}
+
+// args repeat the flags that differ from defaults for a command run in another process.
+func (f logFlags) args() []string {
+	args := make([]string, 0, 3)
+	if f.LogFormat != `text` {
+		args = append(args, `--log-format`, f.LogFormat)
+	}
+	if f.Quiet {
+		args = append(args, `--quiet`)
+	} else if f.Verbose {
+		args = append(args, `--verbose`)
+	}
+	return args
+}
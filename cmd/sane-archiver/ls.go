@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"archiver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+type lsTask struct {
+	Key  string   `kong:"flag,help='Private base64-encoded key.'"`
+	File []string `kong:"arg,required,help='File or s3:// URL to list.',sep=' '"`
+}
+
+// archiveEntry describes a file stored in either archive format.
+type archiveEntry struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+	Link     string // Target of a symbolic or a hard link.
+	Method   string // Compression of the entry, or of the whole stream for tar archives.
+	Open     func() (io.ReadCloser, error)
+}
+
+// openArchive decrypts the archive on demand without reading it whole.
+func openArchive(target string, key string) (io.ReaderAt, int64, archiver.File, error) {
+	in, err := archiver.Open(target)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	r, size, err := archiver.NewSaneReaderAt(in, in.Size(), key)
+	if err != nil {
+		in.Close()
+		return nil, 0, nil, err
+	}
+	return r, size, in, nil
+}
+
+// readEntries detects the format of decrypted archive contents and visits every entry.
+// Zip archives are read from their directory, while tar archives are streamed through.
+func readEntries(r io.ReaderAt, size int64, visit func(archiveEntry) error) (archiver.Format, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return archiver.FormatZip, err
+	}
+	format := archiver.DetectFormat(magic)
+	if format == archiver.FormatZip {
+		z, err := zip.NewReader(r, size)
+		if err != nil {
+			return format, fmt.Errorf(`archive contents are not readable, the key may be wrong: %w`, err)
+		}
+		for _, f := range z.File {
+			if err = visit(archiveEntry{
+				Name:     f.Name,
+				Size:     int64(f.UncompressedSize64),
+				Modified: f.Modified,
+				Method:   archiver.ZipMethodName(f.Method),
+				Open:     f.Open,
+			}); err != nil {
+				return format, err
+			}
+		}
+		return format, nil
+	}
+	stream, err := archiver.Decompress(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return format, fmt.Errorf(`archive contents are not readable, the key may be wrong: %w`, err)
+	}
+	defer stream.Close()
+	method := archiver.DetectCompression(magic).String()
+	archive := tar.NewReader(stream)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			// reading the rest checks the stream checksum
+			_, err = io.Copy(ioutil.Discard, stream)
+			return format, err
+		} else if err != nil {
+			return format, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if err = visit(archiveEntry{
+			Name:     header.Name,
+			Size:     header.Size,
+			Modified: header.ModTime,
+			Link:     header.Linkname,
+			Method:   method,
+			Open:     func() (io.ReadCloser, error) { return ioutil.NopCloser(archive), nil },
+		}); err != nil {
+			return format, err
+		}
+	}
+}
+
+func (c *lsTask) Run(ctx *kong.Context) error {
+	if c.Key == "" {
+		key, err := ReadKey(`Please enter private key (-k) to list target archives:`)
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	defer out.Flush()
+	for _, arg := range c.File {
+		r, size, in, err := openArchive(arg, c.Key)
+		if err != nil {
+			return fmt.Errorf("could not list file <%s>: %w", displayTarget(arg), err)
+		}
+		_, err = readEntries(r, size, func(e archiveEntry) error {
+			name := e.Name
+			if e.Link != `` {
+				name += ` -> ` + e.Link
+			}
+			if e.Name == archiver.ManifestName {
+				if f, err := e.Open(); err == nil {
+					m, err := archiver.ReadManifest(f)
+					f.Close()
+					if err == nil {
+						slog.Info(`Archive has a manifest`, `path`, displayTarget(arg), `version`, m.Version,
+							`host`, m.Host, `created`, m.Created.Local())
+					}
+				}
+			}
+			_, err := fmt.Fprintf(out, "%d\t%s\t%s\t %s\n", e.Size, e.Method, e.Modified.Format(`2006-01-02 15:04`), name)
+			return err
+		})
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("could not list file <%s>: %w", displayTarget(arg), err)
+		}
+	}
+	return nil
+}
@@ -2,41 +2,88 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"strings"
+	"syscall"
 
 	"github.com/alecthomas/kong"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // CLI holds the full configuration for the command line interface.
 var CLI struct {
 	Pack    packTask         `kong:"cmd,help='Pack files or folders into an encrypted archive.'"`
+	Run     runTask          `kong:"cmd,help='Pack a job defined in the configuration file, with flags overriding its settings.'"`
 	Unpack  unpackTask       `kong:"cmd,help='Unpack all provided files.'"`
+	Ls      lsTask           `kong:"cmd,help='List contents of encrypted archives.'"`
+	Info    infoTask         `kong:"cmd,help='Show when, where and from what encrypted archives were made.'"`
+	Verify  verifyTask       `kong:"cmd,help='Check that encrypted archives can be fully recovered.'"`
+	Daemon  daemonTask       `kong:"cmd,help='Run jobs of the configuration file on their schedules.'"`
+	Status  statusTask       `kong:"cmd,help='Show results of recent job runs.'"`
+	Prune   pruneTask        `kong:"cmd,help='Delete older archives that the retention policy does not keep.'"`
+	Seal    sealTask         `kong:"cmd,help='Encrypt any stream, such as a tarball, without packing it into an archive.'"`
+	Open    openTask         `kong:"cmd,help='Decrypt a stream encrypted with seal.'"`
 	Keygen  keygenTask       `kong:"cmd,help='Generate a base64-encoded keypair.'"`
 	Version kong.VersionFlag `kong:"hidden,short='v',help='Display version information.'"`
+	logFlags
 }
 
-// ConfirmOverwrite makes sure user agrees with file overwrite operation.
-func ConfirmOverwrite(target string) {
-	// TODO: this will not work for writer path?
-	if _, err := os.Stat(target); err == nil {
-		fmt.Printf("File <%s> already exists.\nOverwrite? (y/N): ", target)
-		line, _, _ := bufio.NewReader(os.Stdin).ReadLine()
-		answer := strings.ToLower(string(line))
-		if answer != `y` && answer != `yes` {
-			log.Fatal("<CANCELLED> Operation aborted.")
-		}
+const version = `0.1.2`
+
+// ExitFailure is the exit code of a command that failed.
+const ExitFailure = 1
+
+// ExitWarnings is the exit code of a command that completed, but had to leave something out.
+const ExitWarnings = 3
+
+// ExitBusy is the exit code of a job that did not run, because its previous run has not finished.
+const ExitBusy = 4
+
+var errCompletedWithWarnings = errors.New(`completed with warnings`)
+
+// ConfirmOverwrite makes sure user agrees with file overwrite operation. The answer
+// cannot be read when stdin was already used for input or is not a terminal.
+func ConfirmOverwrite(target string, stdinUsed bool) error {
+	if _, err := os.Stat(target); err != nil {
+		return nil
+	}
+	if stdinUsed || !terminal.IsTerminal(int(syscall.Stdin)) {
+		return fmt.Errorf(`file <%s> already exists, use --force to overwrite it`, target)
+	}
+	fmt.Fprintf(os.Stderr, "File <%s> already exists.\nOverwrite? (y/N): ", target)
+	line, _, _ := bufio.NewReader(os.Stdin).ReadLine()
+	answer := strings.ToLower(string(line))
+	if answer != `y` && answer != `yes` {
+		slog.Error(`Operation cancelled`)
+		os.Exit(ExitFailure)
 	}
+	return nil
+}
+
+// ReadKey asks the user to type in a missing key without echoing it.
+// The prompt goes to stderr, so that it does not mix with archives written to stdout.
+func ReadKey(prompt string) (string, error) {
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return ``, fmt.Errorf(`provide the key with --key, it cannot be typed in when stdin is not a terminal`)
+	}
+	fmt.Fprintln(os.Stderr, prompt)
+	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return ``, err
+	}
+	return strings.TrimSpace(string(bytePassword)), nil
 }
 
 func main() {
-	log.SetOutput(os.Stderr)
+	log.SetOutput(logOutput)
 	err := func() error {
 		c, err := kong.New(&CLI,
 			kong.Description(`A simple command line utility for making encrypted archives.`),
-			kong.Vars{"version": "0.1.2"},
+			kong.Vars{"version": version},
 			kong.ConfigureHelp(kong.HelpOptions{
 				Compact: true,
 				Summary: true,
@@ -55,9 +102,18 @@ func main() {
 		if err != nil {
 			return err
 		}
+		if err = CLI.logFlags.setup(); err != nil {
+			return err
+		}
 		return ctx.Run()
 	}()
-	if err != nil {
-		log.Fatalf(`Archiver failed: %s.`, err.Error())
+	if errors.Is(err, errCompletedWithWarnings) {
+		os.Exit(ExitWarnings)
+	} else if errors.Is(err, errJobRunning) {
+		slog.Warn(`Archiver skipped the job`, `error`, err)
+		os.Exit(ExitBusy)
+	} else if err != nil {
+		slog.Error(`Archiver failed`, `error`, err)
+		os.Exit(ExitFailure)
 	}
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -40,3 +43,21 @@ func TestKeygen(t *testing.T) {
 		t.Error(`Keygen result unexpected!`)
 	}
 }
+
+func TestConfirmOverwriteWithoutTerminal(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-overwrite-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, `archive.sane1`)
+	if err = ConfirmOverwrite(p, true); err != nil {
+		t.Errorf(`missing file should not need confirmation: %v`, err)
+	}
+	if err = ioutil.WriteFile(p, []byte(`x`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ConfirmOverwrite(p, true); err == nil || !strings.Contains(err.Error(), `--force`) {
+		t.Errorf(`expected to be told to use --force, got %v`, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"archiver"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alecthomas/kong"
+)
+
+type openTask struct {
+	Key    string `kong:"flag,help='Private base64-encoded key.'"`
+	File   string `kong:"arg,optional,default='-',help='File or s3:// URL to decrypt, or - for stdin.'"`
+	Output string `kong:"flag,name='output',short='o',default='-',help='Write decrypted stream to this file, or - for stdout.'"`
+	Force  bool   `kong:"flag,name='force',short='f',help='Overwrite any files that already exist.'"`
+}
+
+func (c *openTask) Run(ctx *kong.Context) error {
+	if c.Key == "" {
+		key, err := ReadKey(`Please enter private key (-k) to decrypt the stream:`)
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+	var in io.Reader = os.Stdin
+	if c.File != stdio {
+		f, err := archiver.Open(c.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := archiver.NewOpenReader(in, c.Key)
+	if err != nil {
+		return fmt.Errorf(`could not decrypt %s: %w`, c.File, err)
+	}
+	out, err := createOutput(c.Output, c.Force, c.File == stdio)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	return finishOutput(out, err)
+}
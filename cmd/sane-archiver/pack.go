@@ -2,35 +2,68 @@ package main
 
 import (
 	"archiver"
-	"bufio"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 type packTask struct {
-	Key        string   `kong:"flag,help='Public base64-encoded key.',env='SaneArchiverPublicKey'"`
-	Target     []string `kong:"arg,required,help='File or directory to pack.',type='path',sep=' '"`
-	Output     string   `kong:"flag,name='output',short='o',type='path',help='Output to this file or path.'"`
-	Force      bool     `kong:"flag,name='force',short='f',help='Overwrite any files that already exist.'"`
-	Upload     string   `kong:"flag,name='upload',short='u',help='Upload finished archive to the cloud URI endpoint.'"`
-	Warn       uint8    `kong:"flag,name='warn',short='w',help='Warn if the disk is running low on space. Issues a warning if there is less gigabytes left than the specified amount.',default='2'"`
-	Leave      uint8    `kong:"flag,name='leave',short:'l',help='Delete older output-matching files, if more than the specified number.',default='12'"`
-	MasterOnly bool     `kong:"flag,name='master-only',short='m',help='Archive only master branches of git repositories.'"`
-	DryRun     bool     `kong:"flag,name='dry-run',short='n',help='Display operations without writing.'"`
+	Key        string        `kong:"flag,help='Public base64-encoded key.',env='SaneArchiverPublicKey'"`
+	Target     []string      `kong:"arg,optional,help='File or directory to pack, or - to pack stdin as a single entry. More paths can be piped into stdin, one per line.',sep=' '"`
+	FilesFrom  []string      `kong:"flag,name='files-from',short='T',help='Also pack paths listed in this file, one per line, or - for stdin. Can be repeated.'"`
+	Source     []string      `kong:"flag,name='source-cmd',sep='none',help='Archive standard output of a command as an entry, written as name=command, such as db.sql=pg_dump mydb. Can be repeated.'"`
+	StdinName  string        `kong:"flag,name='stdin-name',default='stdin',help='Entry name for stdin packed with the - target.'"`
+	Null       bool          `kong:"flag,name='null',short='0',help='Paths in lists are separated by NUL characters, as printed by find -print0.'"`
+	Output     string        `kong:"flag,name='output',short='o',help='Output to this file or directory, or - for stdout. File names may contain {year}, {month}, {day}, {hour}, {minute}, {host}, {target}, {random} and {hash}.'"`
+	Force      bool          `kong:"flag,name='force',short='f',help='Overwrite any files that already exist.'"`
+	Upload     []string      `kong:"flag,name='upload',short='u',help='Upload finished archive to the cloud URI endpoint. Repeat to upload to several endpoints at once.'"`
+	Retries    int           `kong:"flag,name='upload-retries',env='SaneArchiverUploadRetries',help='Retry failed uploads this many times.'"`
+	Backoff    time.Duration `kong:"flag,name='upload-backoff',default='30s',help='Wait this long before retrying failed uploads, twice as long before each next retry.'"`
+	Require    string        `kong:"flag,name='upload-policy',enum='all,any',default='all',help='Consider upload successful when all or any of the endpoints received the archive.'"`
+	S3Class    string        `kong:"flag,name='s3-storage-class',help='Store uploaded archive in this S3 storage class, such as GLACIER or DEEP_ARCHIVE.'"`
+	S3SSE      string        `kong:"flag,name='s3-sse',help='Encrypt uploaded archive at rest with AES256 or aws:kms.'"`
+	S3KMSKey   string        `kong:"flag,name='s3-kms-key',help='KMS key ID, ARN or alias for aws:kms server-side encryption.'"`
+	S3Lock     string        `kong:"flag,name='s3-lock-mode',help='Object Lock retention mode for uploaded archive: GOVERNANCE or COMPLIANCE.'"`
+	S3LockDays uint          `kong:"flag,name='s3-lock-days',help='Keep uploaded archive locked for this many days.'"`
+	S3Hold     bool          `kong:"flag,name='s3-legal-hold',help='Place an Object Lock legal hold on uploaded archive.'"`
+	Warn       uint8         `kong:"flag,name='warn',short='w',help='Warn if the disk is running low on space. Issues a warning if there is less gigabytes left than the specified amount.',default='2'"`
+	Progress   string        `kong:"flag,name='progress',enum='auto,bar,lines,none',default='auto',help='Report progress on stderr with a bar, with lines of key=value pairs, or not at all. Auto draws a bar when stderr is a terminal.'"`
+	Interval   time.Duration `kong:"flag,name='progress-interval',default='10s',help='Print a progress line this often, unless progress is drawn as a bar.'"`
+	retentionPolicy
+	GitDefault  bool     `kong:"flag,name='git-default-branch',short='m',help='Archive the default branch of git repositories, the one HEAD points to.'"`
+	MasterOnly  bool     `kong:"flag,name='master-only',hidden,help='Deprecated alias of --git-default-branch.'"`
+	GitBranch   []string `kong:"flag,name='git-branch',help='Archive git branches matching this glob pattern, such as release/*. Can be repeated.'"`
+	GitTags     bool     `kong:"flag,name='git-tags',help='Also archive every tag of git repositories.'"`
+	GitActive   uint     `kong:"flag,name='git-active-days',help='Archive only git branches with commits within this many days.'"`
+	GitBundle   bool     `kong:"flag,name='git-bundle',help='Store each git repository as a single bundle with all references and full history.'"`
+	GitDirty    bool     `kong:"flag,name='git-dirty',help='Also store modified, staged and untracked files of git working trees.'"`
+	Exclude     []string `kong:"flag,name='exclude',short='x',help='Skip paths matching this .gitignore-style pattern. Can be repeated.'"`
+	Include     []string `kong:"flag,name='include',help='Keep paths matching this .gitignore-style pattern even if they are excluded. Can be repeated.'"`
+	GitIgnore   bool     `kong:"flag,name='respect-gitignore',help='Also skip paths listed in .gitignore files outside of git repositories.'"`
+	OnError     string   `kong:"flag,name='on-error',enum='abort,skip,skip-and-record',default='abort',help='When a path cannot be stored: abort, skip it, or skip it and list it in the archive.'"`
+	Format      string   `kong:"flag,name='format',enum='zip,tar',default='zip',help='Inner archive format: zip, or tar to preserve ownership, links, device nodes, extended attributes and empty directories.'"`
+	Compress    string   `kong:"flag,name='compression',enum='store,deflate,zstd',default='deflate',help='Compress archived files with store, deflate or zstd.'"`
+	Level       int      `kong:"flag,name='compression-level',help='Compression level: 1-9 for deflate, 1-22 for zstd. Zero picks the default.'"`
+	CompressAll bool     `kong:"flag,name='compress-all',help='Compress every file, even those that look already compressed, such as images or archives.'"`
+	Pad         string   `kong:"flag,name='pad',enum='none,pow2,padme,random',default='none',help='Hide archive size with padding: none, pow2 to the next power of two, padme to within 12%, or random.'"`
+	PadRange    uint64   `kong:"flag,name='pad-range',default='1024',help='Largest amount of random padding in kilobytes.'"`
+	Jobs        int      `kong:"flag,name='jobs',short='j',help='Compress this many files at once. Zero uses every CPU core.'"`
+	DryRun      bool     `kong:"flag,name='dry-run',short='n',help='Display operations without writing.'"`
 }
 
-func (t *packTask) outputDirFile() (string, string, error) {
-	p := filepath.Clean(t.Output)
+// splitOutput separates the output directory from the output name template.
+// A directory is given the default template.
+func splitOutput(output string) (string, string, error) {
+	p := filepath.Clean(output)
 	dir := filepath.Dir(p)
 	info, err := os.Stat(p)
 	if err != nil {
@@ -41,91 +74,421 @@ func (t *packTask) outputDirFile() (string, string, error) {
 		return ``, ``, fmt.Errorf("directory %s does not exist", dir)
 	}
 	if info.IsDir() {
-		return dir, `{year}-{month}-{day}-{md5}.sane1`, nil
+		return p, defaultOutputName, nil
 	}
 	return dir, filepath.Base(p), nil
 }
 
-func (t *packTask) Run(ctx *kong.Context) error {
-	outputDir, outputFile, err := t.outputDirFile()
+func (t *packTask) s3Options() archiver.S3Options {
+	return archiver.S3Options{
+		StorageClass:         t.S3Class,
+		ServerSideEncryption: t.S3SSE,
+		KMSKeyID:             t.S3KMSKey,
+		LockMode:             t.S3Lock,
+		LockPeriod:           time.Duration(t.S3LockDays) * 24 * time.Hour,
+		LegalHold:            t.S3Hold,
+	}
+}
+
+// upload pushes the output to every endpoint and checks the results against the upload policy.
+func (t *packTask) upload(progress *archiver.Progress) error {
+	slog.Info(`Uploading the archive`, `endpoints`, len(t.Upload))
+	succeeded, pending, backoff := 0, t.Upload, t.Backoff
+	satisfied := func() bool {
+		return succeeded == len(t.Upload) || (t.Require == `any` && succeeded > 0)
+	}
+	info, err := os.Stat(t.Output)
 	if err != nil {
 		return err
 	}
-	if t.Key == "" {
-		fmt.Println(`Please enter public key (-k) to create encrypted archive:`)
-		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
-		if err != nil {
-			return err
+	options := &archiver.UploadOptions{S3: t.s3Options(), Progress: progress}
+	for attempt := 0; ; attempt++ {
+		// retried uploads are read again from the start
+		progress.AddUploadTotal(info.Size() * int64(len(pending)))
+		failed := make([]string, 0)
+		for _, result := range archiver.UploadAll(t.Output, pending, options) {
+			if result.Err == nil {
+				succeeded++
+				slog.Info(`Upload succeeded`, `url`, archiver.RedactURL(result.URL))
+			} else {
+				failed = append(failed, result.URL)
+				slog.Warn(`Upload failed`, `url`, archiver.RedactURL(result.URL), `error`, result.Err)
+			}
+		}
+		pending = failed
+		if satisfied() || attempt >= t.Retries {
+			break
 		}
-		t.Key = strings.TrimSpace(string(bytePassword))
+		slog.Info(`Retrying failed uploads`, `uploads`, len(pending), `backoff`, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
-	// Check if there are additional sources in STDIN.
+	slog.Info(`Upload finished`, `succeeded`, succeeded, `endpoints`, len(t.Upload))
+	if !satisfied() {
+		return fmt.Errorf(`uploading %s did not satisfy the "%s" upload policy`, t.Output, t.Require)
+	}
+	return nil
+}
+
+// readTargets adds paths listed in files and piped into stdin to targets, dropping duplicates.
+func (t *packTask) readTargets() error {
+	lists := t.FilesFrom
 	info, err := os.Stdin.Stat()
 	if err != nil {
 		return err
 	}
-	if (info.Mode() & os.ModeCharDevice) == 0 {
-		scanner := bufio.NewScanner(os.Stdin)
-		args := t.Target
-		for scanner.Scan() {
-			args = append(args, scanner.Text())
+	packStdin := false
+	for i, target := range t.Target {
+		if target == stdio {
+			packStdin = true
+		} else {
+			t.Target[i] = kong.ExpandPath(target)
+		}
+	}
+	piped := (info.Mode()&os.ModeCharDevice) == 0 && !packStdin
+	for _, list := range lists {
+		if list == stdio && packStdin {
+			return fmt.Errorf(`stdin cannot be both packed and read as a list of paths`)
+		} else if list == stdio {
+			piped = false // already read as a list
 		}
 	}
+	if piped {
+		lists = append(lists, `-`)
+	}
+	for _, list := range lists {
+		targets, err := readTargetsFrom(list, t.Null)
+		if err != nil {
+			return fmt.Errorf(`could not read list of paths from %s: %w`, list, err)
+		}
+		t.Target = append(t.Target, targets...)
+	}
+	t.Target = dedupTargets(t.Target)
+	if len(t.Target) == 0 && len(t.Source) == 0 {
+		return fmt.Errorf(`nothing to pack, provide paths as arguments, with --files-from, through stdin, or use --source-cmd`)
+	}
+	return nil
+}
+
+// addStdin packs the whole of stdin as a single entry.
+func (t *packTask) addStdin(w *archiver.SaneWriter) error {
+	if t.DryRun {
+		slog.Info(`Skipping stdin for dryrun`, `dryrun`, true)
+		return nil
+	}
+	var r io.Reader = os.Stdin
+	if err := w.AddReader(t.StdinName, &r); err != nil {
+		return fmt.Errorf(`could not pack stdin: %w`, err)
+	}
+	return nil
+}
 
-	// display some warnings // TODO: make this a separate suite
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(outputDir, &stat); err != nil {
-		log.Printf("<WARNING> Storage device at path <%s> cannot be accessed!", outputDir)
-	} else if (stat.Bavail * uint64(stat.Bsize)) < uint64(t.Warn)*1024*1024*1024 {
-		log.Printf("<WARNING> Storage device at path <%s> has less than %dGB of space left!",
-			outputDir, t.Warn)
+func (t *packTask) commandSources() ([]archiver.CommandSource, error) {
+	sources := make([]archiver.CommandSource, 0, len(t.Source))
+	for _, definition := range t.Source {
+		source, err := archiver.ParseCommandSource(definition)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
 	}
+	return sources, nil
+}
 
-	tmpfile, err := ioutil.TempFile(outputDir, ".sane-archiver-*.tmp")
+// addCommands stores output of every command source, applying the error policy to failed commands.
+func (t *packTask) addCommands(w *archiver.SaneWriter, sources []archiver.CommandSource) ([]archiver.SkippedPath, error) {
+	skipped := make([]archiver.SkippedPath, 0)
+	for _, source := range sources {
+		if t.DryRun {
+			slog.Info(`Skipping command for dryrun`, `command`, source.Command, `name`, source.Name, `dryrun`, true)
+			continue
+		}
+		err := w.AddCommand(source)
+		if err == nil {
+			continue
+		} else if archiver.ErrorPolicies[t.OnError] == archiver.ErrorAbort {
+			return nil, fmt.Errorf(`could not pack %s: %w`, source.Name, err)
+		}
+		slog.Warn(`Skipping command output`, `name`, source.Name, `error`, err)
+		skipped = append(skipped, archiver.SkippedPath{Path: source.Name, Err: err})
+	}
+	return skipped, nil
+}
+
+func (t *packTask) padding() archiver.Padding {
+	return archiver.Padding{Policy: archiver.PaddingPolicies[t.Pad], Range: t.PadRange * 1024}
+}
+
+func (t *packTask) gitSelection() archiver.GitSelection {
+	return archiver.GitSelection{
+		DefaultBranch: t.GitDefault || t.MasterOnly,
+		Branches:      t.GitBranch,
+		Tags:          t.GitTags,
+		ActiveDays:    t.GitActive,
+	}
+}
+
+// walker walks a target with the selected git, ignore and error settings.
+func (t *packTask) walker(target string) *archiver.SaneDirectoryWalker {
+	return &archiver.SaneDirectoryWalker{
+		Target: target,
+		Git:    t.gitSelection(),
+		Ignore: archiver.IgnoreRules{
+			Exclude:          t.Exclude,
+			Include:          t.Include,
+			RespectGitignore: t.GitIgnore,
+		},
+		Bundle: t.GitBundle,
+		Dirty:  t.GitDirty,
+		Dryrun: t.DryRun,
+		Policy: archiver.ErrorPolicies[t.OnError],
+	}
+}
+
+// manifest describes the run, so that it can be told apart from others after decryption.
+func (t *packTask) manifest(sources []archiver.CommandSource) *archiver.Manifest {
+	host, err := os.Hostname()
+	if err != nil {
+		slog.Warn(`Host name could not be determined`, `error`, err)
+	}
+	m := &archiver.Manifest{
+		Version: version,
+		Host:    host,
+		Created: time.Now().UTC(),
+		Targets: t.Target,
+	}
+	for _, source := range sources {
+		m.Sources = append(m.Sources, source.Name)
+	}
+	return m
+}
+
+// packsStdin tells whether stdin is one of the targets, so that it cannot be asked for answers.
+func (t *packTask) packsStdin() bool {
+	for _, target := range t.Target {
+		if target == stdio {
+			return true
+		}
+	}
+	return false
+}
+
+// targetName is the base name of the first target, which fills in the {target} placeholder.
+func (t *packTask) targetName(sources []archiver.CommandSource) string {
+	switch {
+	case len(t.Target) > 0 && t.Target[0] == stdio:
+		return filepath.Base(t.StdinName)
+	case len(t.Target) > 0:
+		return filepath.Base(t.Target[0])
+	case len(sources) > 0:
+		return filepath.Base(sources[0].Name)
+	}
+	return ``
+}
+
+func (t *packTask) Run(ctx *kong.Context) error {
+	toStdout := t.Output == stdio
+	var outputDir, outputFile string
+	var template *outputTemplate
+	var err error
+	if toStdout {
+		if len(t.Upload) > 0 {
+			return fmt.Errorf(`archive written to stdout cannot be uploaded`)
+		}
+		if t.retentionPolicy.IsSet() {
+			return fmt.Errorf(`older archives cannot be pruned when the archive is written to stdout`)
+		}
+	} else {
+		if t.Output != `` {
+			t.Output = kong.ExpandPath(t.Output)
+		}
+		if outputDir, outputFile, err = splitOutput(t.Output); err != nil {
+			return err
+		}
+		if template, err = parseTemplate(outputFile); err != nil {
+			return err
+		}
+	}
+	if err = t.retentionPolicy.Validate(); err != nil {
+		return err
+	}
+	if err = t.s3Options().Validate(); err != nil {
+		return err
+	}
+	if t.MasterOnly {
+		slog.Warn(`--master-only is deprecated, use --git-default-branch instead`)
+	}
+	if err = t.gitSelection().Validate(); err != nil {
+		return err
+	}
+	sources, err := t.commandSources()
 	if err != nil {
 		return err
 	}
-	w := &archiver.SaneWriter{PublicKey: t.Key, Writer: tmpfile}
-	defer func() {
-		w.Close()
-		tmpfile.Close()
-	}()
+	if err = archiver.Compressions[t.Compress].ValidateLevel(t.Level); err != nil {
+		return err
+	}
+	if err = t.padding().Validate(); err != nil {
+		return err
+	}
+	if t.Key == "" {
+		key, err := ReadKey(`Please enter public key (-k) to create encrypted archive:`)
+		if err != nil {
+			return err
+		}
+		t.Key = key
+	}
+	if err = t.readTargets(); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	var tmpfile *os.File
+	if !toStdout {
+		// display some warnings // TODO: make this a separate suite
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(outputDir, &stat); err != nil {
+			slog.Warn(`Storage device cannot be accessed`, `path`, outputDir, `error`, err)
+		} else if (stat.Bavail * uint64(stat.Bsize)) < uint64(t.Warn)*1024*1024*1024 {
+			slog.Warn(`Storage device is running low on space`, `path`, outputDir,
+				`available`, stat.Bavail*uint64(stat.Bsize), `threshold_gb`, t.Warn)
+		}
+
+		tmpfile, err = ioutil.TempFile(outputDir, ".sane-archiver-*.tmp")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if tmpfile != nil { // archive was not completed
+				tmpfile.Close()
+				os.Remove(tmpfile.Name())
+			}
+		}()
+		out = tmpfile
+	}
+	w := &archiver.SaneWriter{
+		PublicKey:        t.Key,
+		Writer:           out,
+		Format:           archiver.Formats[t.Format],
+		Compression:      archiver.Compressions[t.Compress],
+		CompressionLevel: t.Level,
+		CompressAll:      t.CompressAll,
+		Jobs:             t.Jobs,
+		Padding:          t.padding(),
+		Manifest:         t.manifest(sources),
+	}
+	if w.Jobs <= 0 {
+		w.Jobs = runtime.NumCPU()
+	}
 
+	progress := &archiver.Progress{}
+	reporter := newProgressReporter(t.Progress, t.Interval, progress)
+	reporter.Start()
+	defer reporter.Stop()
+	// totals are only shown by the reporter, and dry runs pack nothing to measure
+	if reporter != nil && !t.DryRun {
+		for _, arg := range t.Target {
+			if arg != stdio {
+				files, size, err := t.walker(arg).Count()
+				if err != nil {
+					slog.Warn(`Path could not be scanned`, `path`, arg, `error`, err)
+				}
+				progress.AddTotal(files, size)
+			}
+		}
+	}
+	progress.SetStage(archiver.StagePacking)
+	w.Progress = progress
+
+	skipped := make([]archiver.SkippedPath, 0)
 	for _, arg := range t.Target {
-		a := &archiver.SaneDirectoryWalker{
-			Target: arg,
-			Master: t.MasterOnly,
-			Dryrun: t.DryRun,
+		if arg == stdio {
+			if err = t.addStdin(w); err != nil {
+				return err
+			}
+			continue
 		}
+		a := t.walker(arg)
 		err = a.Walk(w)
 		if err != nil {
 			return fmt.Errorf(`could not pack %s: %w`, arg, err)
 		}
+		skipped = append(skipped, a.Skipped...)
 	}
-	n := time.Now()
-	p := strings.Replace(outputFile, "{day}", fmt.Sprintf("%d", n.Day()), 1)
-	p = strings.Replace(p, "{month}", fmt.Sprintf("%d", n.Month()), 1)
-	p = strings.Replace(p, "{year}", fmt.Sprintf("%d", n.Year()), 1)
-	p = strings.Replace(p, "{md5}", hex.EncodeToString(w.Hash.Sum(nil)), 1)
-	t.Output = filepath.Join(outputDir, p)
-	ConfirmOverwrite(t.Output)
-	err = os.Rename(tmpfile.Name(), t.Output)
+	failed, err := t.addCommands(w, sources)
 	if err != nil {
-		return fmt.Errorf("cannot move file %s: %w", tmpfile.Name(), err)
+		return err
 	}
-	// TODO: replace this with progress bar
-	log.Printf("Wrote %.2fGB to <%s>.", float64(w.Size)/(1024*1024*1024), t.Output)
-	os.Stdout.WriteString(t.Output + "\n")
-
-	if t.Upload != "" {
-		log.Println(`Attemping to upload result...`)
-		err := archiver.UploadS3(t.Output, t.Upload)
+	skipped = append(skipped, failed...)
+	if t.DryRun {
+		progress.SetStage(archiver.StageDone)
+		return nil
+	}
+	for _, s := range skipped {
+		w.Manifest.Warnings = append(w.Manifest.Warnings, s.String())
+	}
+	if len(skipped) > 0 && t.OnError == `skip-and-record` {
+		if err = archiver.WriteErrorReport(w, skipped); err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if toStdout {
+		progress.SetStage(archiver.StageDone)
+		slog.Info(`Wrote the archive to stdout`, `size`, w.Size,
+			`md5`, hex.EncodeToString(w.Hash.Sum(nil)))
+	} else {
+		if err = tmpfile.Close(); err != nil {
+			return err
+		}
+		progress.SetStage(archiver.StageDone)
+		reporter.Stop() // the overwrite prompt must not be drawn over
+		values := templateValues{
+			Time:   w.Manifest.Created.Local(),
+			Host:   w.Manifest.Host,
+			Target: t.targetName(sources),
+			Hash:   hex.EncodeToString(w.Hash.Sum(nil)),
+		}
+		t.Output = filepath.Join(outputDir, template.Render(values))
+		if !t.Force {
+			if err = ConfirmOverwrite(t.Output, t.packsStdin()); err != nil {
+				return err
+			}
+		}
+		err = os.Rename(tmpfile.Name(), t.Output)
 		if err != nil {
-			return fmt.Errorf(`uploading %s: %w`, p, err)
+			return fmt.Errorf("cannot move file %s: %w", tmpfile.Name(), err)
+		}
+		tmpfile = nil
+		slog.Info(`Wrote the archive`, `path`, t.Output, `size`, w.Size)
+		os.Stdout.WriteString(t.Output + "\n")
+
+		if len(t.Upload) > 0 {
+			progress.SetStage(archiver.StageUploading)
+			reporter.Start()
+			err = t.upload(progress)
+			progress.SetStage(archiver.StageDone)
+			reporter.Stop()
+			if err != nil {
+				return err
+			}
+		}
+		if t.retentionPolicy.IsSet() {
+			// archives of other hosts and targets sharing the directory are left alone
+			if err = prune(outputDir, template.Narrow(values), t.retentionPolicy, false); err != nil {
+				return err
+			}
 		}
 	}
-	if t.Leave > 0 {
-		err = eliminateAllExcept(t.Output, int(t.Leave))
+	if len(skipped) > 0 {
+		paths := make([]string, 0, len(skipped))
+		for _, s := range skipped {
+			paths = append(paths, s.String())
+		}
+		slog.Warn(`Paths were skipped because of errors`, `skipped`, len(skipped), `paths`, paths)
+		return errCompletedWithWarnings
 	}
-	return err
+	return nil
 }
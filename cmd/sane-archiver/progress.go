@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archiver"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// barInterval is how often the progress bar is redrawn.
+const barInterval = 200 * time.Millisecond
+
+// progressReporter shows progress on stderr, so that it does not mix with the output
+// path or the archive written to stdout. On a terminal it draws a bar below log lines,
+// otherwise it logs progress every interval.
+type progressReporter struct {
+	progress *archiver.Progress
+	bar      bool
+	interval time.Duration
+	out      io.Writer
+	started  time.Time
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newProgressReporter picks the bar or lines by mode: auto, bar, lines or none.
+// Nothing is reported for none.
+func newProgressReporter(mode string, interval time.Duration, p *archiver.Progress) *progressReporter {
+	r := &progressReporter{progress: p, interval: interval, out: os.Stderr, started: time.Now()}
+	switch mode {
+	case `none`:
+		return nil
+	case `auto`:
+		r.bar = !logJSON && terminal.IsTerminal(int(os.Stderr.Fd()))
+	case `bar`:
+		r.bar = true
+	}
+	if r.bar {
+		r.interval = barInterval
+	}
+	return r
+}
+
+// Start reports progress in the background until Stop. Log lines are routed through
+// the reporter, so that they are printed above the bar.
+func (r *progressReporter) Start() {
+	if r == nil || r.stop != nil {
+		return
+	}
+	r.stop, r.done = make(chan struct{}), make(chan struct{})
+	if r.bar {
+		logOutput.Set(r)
+	}
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop reports progress for the last time and returns the log to stderr.
+func (r *progressReporter) Stop() {
+	if r == nil || r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.stop, r.done = nil, nil
+	r.report()
+	if r.bar {
+		logOutput.Set(os.Stderr)
+		fmt.Fprintln(r.out)
+	}
+}
+
+// Write prints a log line in place of the bar and draws the bar again below it.
+func (r *progressReporter) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprint(r.out, "\r\x1b[K")
+	n, err := r.out.Write(b)
+	fmt.Fprint(r.out, r.line(r.progress.Snapshot()))
+	return n, err
+}
+
+func (r *progressReporter) report() {
+	s := r.progress.Snapshot()
+	if r.bar {
+		r.mu.Lock()
+		fmt.Fprint(r.out, "\r\x1b[K"+r.line(s))
+		r.mu.Unlock()
+		return
+	}
+	attrs := []interface{}{`stage`, s.Stage.String(), `files`, s.Files, `total_files`, s.TotalFiles,
+		`bytes`, s.Bytes, `total_bytes`, s.TotalBytes, `uploaded`, s.Uploaded, `upload_total`, s.UploadTotal}
+	if p, known := stagePercent(s); known {
+		attrs = append(attrs, `percent`, math.Round(p*10)/10)
+	}
+	attrs = append(attrs, `elapsed`, time.Since(r.started).Round(time.Second).String())
+	slog.Info(`Progress`, attrs...)
+}
+
+// stagePercent is the share of bytes processed in the current stage, if the total is known.
+// It stays below 100 until the work is done, because git repositories and command output
+// are not accounted for in advance.
+func stagePercent(s archiver.ProgressSnapshot) (float64, bool) {
+	done, total := s.Bytes, s.TotalBytes
+	switch s.Stage {
+	case archiver.StageScanning:
+		return 0, false
+	case archiver.StageUploading:
+		done, total = s.Uploaded, s.UploadTotal
+	case archiver.StageDone:
+		return 100, true
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	p := float64(done) * 100 / float64(total)
+	if p > 99.9 {
+		p = 99.9
+	}
+	return p, true
+}
+
+// line draws the bar, fitted into the width of the terminal.
+func (r *progressReporter) line(s archiver.ProgressSnapshot) string {
+	elapsed := time.Since(r.started).Round(time.Second)
+	var text string
+	switch s.Stage {
+	case archiver.StageScanning:
+		text = fmt.Sprintf(`scanning  %d files  %s  %s`, s.TotalFiles, humanBytes(s.TotalBytes), elapsed)
+	case archiver.StageUploading:
+		text = fmt.Sprintf(`%s/%s  %s`, humanBytes(s.Uploaded), humanBytes(s.UploadTotal), elapsed)
+	default:
+		text = fmt.Sprintf(`%d/%d files  %s/%s  %s`, s.Files, s.TotalFiles,
+			humanBytes(s.Bytes), humanBytes(s.TotalBytes), elapsed)
+	}
+	width, _, err := terminal.GetSize(int(os.Stderr.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	if p, known := stagePercent(s); known {
+		if size := width - len(text) - len(s.Stage.String()) - 12; size >= 10 {
+			filled := int(p * float64(size) / 100)
+			text = fmt.Sprintf(`%s [%s%s] %5.1f%%  %s`, s.Stage, strings.Repeat(`=`, filled),
+				strings.Repeat(` `, size-filled), p, text)
+		} else {
+			text = fmt.Sprintf(`%s %5.1f%%  %s`, s.Stage, p, text)
+		}
+	} else if s.Stage != archiver.StageScanning {
+		text = fmt.Sprintf(`%s  %s`, s.Stage, text)
+	}
+	if len(text) >= width {
+		text = text[:width-1]
+	}
+	return text
+}
+
+// humanBytes formats a size with a binary unit.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf(`%dB`, n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf(`%.1f%ciB`, float64(n)/float64(div), `KMGTPE`[exp])
+}
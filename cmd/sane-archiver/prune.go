@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/kong"
+)
+
+type pruneTask struct {
+	Output string `kong:"arg,optional,help='Output template of the archives, such as backups/{year}-{month}-{day}-{hash}.sane1, or the directory holding them.'"`
+	retentionPolicy
+	Host   string `kong:"flag,name='host',help='Prune only archives of this host, required when the output template has a {host}.'"`
+	Target string `kong:"flag,name='target',help='Prune only archives of this target, required when the output template has a {target}.'"`
+	DryRun bool   `kong:"flag,name='dry-run',short='n',help='Display archives that would be deleted without deleting them.'"`
+}
+
+func (t *pruneTask) Run(ctx *kong.Context) error {
+	if err := t.retentionPolicy.Validate(); err != nil {
+		return err
+	}
+	if !t.retentionPolicy.IsSet() {
+		return fmt.Errorf(`provide a retention policy, such as --keep-daily 7, to decide which archives are kept`)
+	}
+	if t.Output != `` {
+		t.Output = kong.ExpandPath(t.Output)
+	}
+	dir, file, err := splitOutput(t.Output)
+	if err != nil {
+		return err
+	}
+	template, err := parseTemplate(file)
+	if err != nil {
+		return err
+	}
+	// archives of several hosts or targets would be counted against each other
+	if template.Has(`host`) && t.Host == `` {
+		return fmt.Errorf(`output template <%s> has a {host}, provide --host to prune archives of one host`, file)
+	}
+	if template.Has(`target`) && t.Target == `` {
+		return fmt.Errorf(`output template <%s> has a {target}, provide --target to prune archives of one target`, file)
+	}
+	return prune(dir, template.Narrow(templateValues{Host: t.Host, Target: t.Target}), t.retentionPolicy, t.DryRun)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultOutputName is the output template used when the output is a directory.
+const defaultOutputName = `{year}-{month}-{day}-{hash}.sane1`
+
+// retentionPolicy decides which of the archives matching the output template are kept.
+// An archive is kept if any of the rules keeps it, and nothing is deleted unless a rule is given.
+type retentionPolicy struct {
+	Leave   int `kong:"flag,name='leave',short='l',help='Keep this many of the newest output-matching archives.'"`
+	Daily   int `kong:"flag,name='keep-daily',help='Keep the newest archive of each of this many most recent days.'"`
+	Weekly  int `kong:"flag,name='keep-weekly',help='Keep the newest archive of each of this many most recent weeks.'"`
+	Monthly int `kong:"flag,name='keep-monthly',help='Keep the newest archive of each of this many most recent months.'"`
+	Yearly  int `kong:"flag,name='keep-yearly',help='Keep the newest archive of each of this many most recent years.'"`
+}
+
+// Validate checks that none of the rules is negative.
+func (r retentionPolicy) Validate() error {
+	if r.Leave < 0 || r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 || r.Yearly < 0 {
+		return fmt.Errorf(`retention counts cannot be negative`)
+	}
+	return nil
+}
+
+// IsSet is true if any of the rules is given.
+func (r retentionPolicy) IsSet() bool {
+	return r.Leave+r.Daily+r.Weekly+r.Monthly+r.Yearly > 0
+}
+
+// datedArchive is an archive that matched the output template.
+type datedArchive struct {
+	Path string
+	Date time.Time
+}
+
+// findArchives lists archives in the directory that match the output template, dated by
+// the date encoded in their names, because modification times change when archives are
+// copied. Templates without a {year} fall back to modification times.
+func findArchives(dir string, template *outputTemplate) ([]datedArchive, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	list, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	dated := template.Has(`year`)
+	if !dated {
+		slog.Warn(`Output does not encode a {year}, archives are dated by modification time`, `output`, template.String())
+	}
+	result := make([]datedArchive, 0, len(list))
+	mtime := make(map[string]time.Time, len(list))
+	for _, info := range list {
+		if info.IsDir() {
+			continue
+		}
+		v, err := template.Parse(info.Name())
+		if errors.Is(err, errTemplateMismatch) {
+			continue
+		}
+		a := datedArchive{Path: filepath.Join(dir, info.Name()), Date: info.ModTime()}
+		if err != nil {
+			slog.Warn(`Skipping archive`, `path`, a.Path, `error`, err)
+			continue
+		}
+		if dated {
+			a.Date = v.Time
+		}
+		mtime[a.Path] = info.ModTime()
+		result = append(result, a)
+	}
+	// archives of the same date are told apart by modification time
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Date.Equal(result[j].Date) {
+			return result[i].Date.After(result[j].Date)
+		}
+		return mtime[result[i].Path].After(mtime[result[j].Path])
+	})
+	return result, nil
+}
+
+// apply splits archives, sorted from the newest, into those kept and those deleted.
+// Each kept archive is given the reasons it was kept for.
+func (r retentionPolicy) apply(archives []datedArchive) (keep map[string][]string, remove []datedArchive) {
+	keep = make(map[string][]string)
+	rule := func(name string, limit int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for i, a := range archives {
+			if len(seen) >= limit {
+				return
+			}
+			key := strconv.Itoa(i)
+			if period != nil {
+				key = period(a.Date)
+			}
+			if !seen[key] {
+				seen[key] = true
+				keep[a.Path] = append(keep[a.Path], name)
+			}
+		}
+	}
+	rule(`newest`, r.Leave, nil)
+	rule(`daily`, r.Daily, func(t time.Time) string { return t.Format(`2006-01-02`) })
+	rule(`weekly`, r.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf(`%d-W%02d`, year, week)
+	})
+	rule(`monthly`, r.Monthly, func(t time.Time) string { return t.Format(`2006-01`) })
+	rule(`yearly`, r.Yearly, func(t time.Time) string { return t.Format(`2006`) })
+	for _, a := range archives {
+		if _, ok := keep[a.Path]; !ok {
+			remove = append(remove, a)
+		}
+	}
+	return keep, remove
+}
+
+// prune deletes archives in the directory matching the output template that the policy does not keep.
+func prune(dir string, template *outputTemplate, r retentionPolicy, dryRun bool) error {
+	archives, err := findArchives(dir, template)
+	if err != nil {
+		return err
+	}
+	keep, remove := r.apply(archives)
+	if dryRun {
+		for _, a := range archives {
+			if reasons, ok := keep[a.Path]; ok {
+				slog.Info(`Keeping archive`, `path`, a.Path, `reasons`, strings.Join(reasons, `, `))
+			}
+		}
+	}
+	for _, a := range remove {
+		if dryRun {
+			slog.Info(`Would delete archive`, `path`, a.Path, `dryrun`, true)
+			continue
+		}
+		if err = os.Remove(a.Path); err != nil {
+			return err
+		}
+		slog.Info(`Deleted archive not kept by the retention policy`, `path`, a.Path)
+	}
+	if dryRun {
+		slog.Info(`Would prune archives`, `kept`, len(keep), `deleted`, len(remove), `archives`, len(archives),
+			`output`, filepath.Join(dir, template.String()), `dryrun`, true)
+	} else {
+		slog.Info(`Pruned archives`, `kept`, len(keep), `deleted`, len(remove), `archives`, len(archives),
+			`output`, filepath.Join(dir, template.String()))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-retention-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	copied := time.Now()
+	for _, name := range []string{
+		`2026-10-19-0123456789.sane1`,
+		`2026-10-18-0123456789.sane1`,
+		`2026-10-18-abcdefabcd.sane1`, // older, modified an hour before the one above
+		`2026-10-12-0123456789.sane1`,
+		`2026-09-30-0123456789.sane1`,
+		`2026-9-1-0123456789.sane1`,
+		`2025-12-31-0123456789.sane1`,
+		`2024-6-1-0123456789.sane1`,
+		`notes.txt`,
+	} {
+		p := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// copying resets modification times, which must not matter
+		modified := copied
+		if name == `2026-10-18-abcdefabcd.sane1` {
+			modified = copied.Add(-time.Hour)
+		}
+		if err = os.Chtimes(p, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template, err := parseTemplate(defaultOutputName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archives, err := findArchives(dir, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 8 {
+		t.Fatalf(`found %d archives instead of 8`, len(archives))
+	}
+
+	cases := []struct {
+		policy retentionPolicy
+		remove []string
+	}{
+		{retentionPolicy{Leave: 6}, []string{`2025-12-31-0123456789.sane1`, `2024-6-1-0123456789.sane1`}},
+		{retentionPolicy{Daily: 3}, []string{
+			`2026-10-18-abcdefabcd.sane1`, `2026-09-30-0123456789.sane1`, `2026-9-1-0123456789.sane1`,
+			`2025-12-31-0123456789.sane1`, `2024-6-1-0123456789.sane1`,
+		}},
+		// weeks start on Monday, so the 12th and the 18th share one
+		{retentionPolicy{Weekly: 2, Yearly: 3}, []string{
+			`2026-10-18-abcdefabcd.sane1`, `2026-10-12-0123456789.sane1`,
+			`2026-09-30-0123456789.sane1`, `2026-9-1-0123456789.sane1`,
+		}},
+		{retentionPolicy{Daily: 1, Monthly: 2}, []string{
+			`2026-10-18-0123456789.sane1`, `2026-10-18-abcdefabcd.sane1`, `2026-10-12-0123456789.sane1`,
+			`2026-9-1-0123456789.sane1`, `2025-12-31-0123456789.sane1`, `2024-6-1-0123456789.sane1`,
+		}},
+	}
+	for _, c := range cases {
+		keep, remove := c.policy.apply(archives)
+		names := make([]string, 0, len(remove))
+		for _, a := range remove {
+			names = append(names, filepath.Base(a.Path))
+		}
+		if !reflect.DeepEqual(names, c.remove) {
+			t.Errorf(`policy %+v deletes %q instead of %q`, c.policy, names, c.remove)
+		}
+		if len(keep)+len(remove) != len(archives) {
+			t.Errorf(`policy %+v loses track of archives`, c.policy)
+		}
+	}
+}
+
+func TestRetentionOfOneTarget(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-retention-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		`box-docs-2026-10-19.sane1`,
+		`box-docs-2026-10-18.sane1`,
+		`box-photos-2026-10-19.sane1`,
+		`box-photos-2026-10-18.sane1`,
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	template, err := parseTemplate(`{host}-{target}-{year}-{month}-{day}.sane1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = prune(dir, template.Narrow(templateValues{Host: `box`, Target: `docs`}), retentionPolicy{Leave: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(list))
+	for _, info := range list {
+		names = append(names, info.Name())
+	}
+	expected := []string{`box-docs-2026-10-19.sane1`, `box-photos-2026-10-18.sane1`, `box-photos-2026-10-19.sane1`}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf(`expected %q to remain, got %q`, expected, names)
+	}
+}
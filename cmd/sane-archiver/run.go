@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+type runTask struct {
+	Job    string `kong:"arg,help='Name of the job defined in the configuration file.'"`
+	Config string `kong:"flag,name='config',short='c',env='SaneArchiverConfig',help='Job configuration file, ~/.config/sane-archiver/jobs.yaml by default.'"`
+	packTask
+	Scheduled bool `kong:"flag,name='scheduled',hidden,help='Leave recording the result to the scheduler that started the run.'"`
+
+	settings *jobSettings
+}
+
+// BeforeResolve loads the job before flags are resolved, so that its settings
+// fill in the flags that were not given on the command line.
+func (t *runTask) BeforeResolve(ctx *kong.Context) error {
+	job, config := ``, t.Config // the environment is applied already
+	for _, p := range ctx.Path {
+		switch {
+		case p.Positional != nil && p.Positional.Name == `job`:
+			job = ctx.Value(p).String()
+		case p.Flag != nil && p.Flag.Name == `config`:
+			config = ctx.Value(p).String()
+		case p.Flag != nil && p.Flag.Name == `help`:
+			return nil
+		}
+	}
+	if job == `` {
+		return nil // kong reports the missing argument
+	}
+	c, err := loadJobConfig(configPath(config))
+	if err != nil {
+		return err
+	}
+	if t.settings, err = c.job(job, ctx.Flags()); err != nil {
+		return err
+	}
+	ctx.AddResolver(t.settings)
+	return nil
+}
+
+// Run packs the job, unless another run of it has not finished yet. The result is
+// recorded in the history, so that status shows runs started by hand as well.
+func (t *runTask) Run(ctx *kong.Context) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	r := jobResult{Job: t.Job, Started: time.Now()}
+	err = t.run(ctx, dir)
+	if !t.Scheduled {
+		r.Finished = time.Now()
+		r.Status, r.Error = jobStatus(err)
+		if err := appendHistory(dir, r); err != nil {
+			slog.Warn(`Job result could not be recorded`, `job`, r.Job, `error`, err)
+		}
+	}
+	return err
+}
+
+func (t *runTask) run(ctx *kong.Context, dir string) error {
+	lock, err := lockJob(dir, t.Job)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if len(t.Target) == 0 && t.settings != nil {
+		t.Target = t.settings.targets
+	}
+	return t.packTask.Run(ctx)
+}
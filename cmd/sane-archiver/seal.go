@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archiver"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alecthomas/kong"
+)
+
+type sealTask struct {
+	Key      string `kong:"flag,help='Public base64-encoded key.',env='SaneArchiverPublicKey'"`
+	File     string `kong:"arg,optional,default='-',help='File to encrypt, or - for stdin.'"`
+	Output   string `kong:"flag,name='output',short='o',default='-',help='Write encrypted stream to this file, or - for stdout.'"`
+	Force    bool   `kong:"flag,name='force',short='f',help='Overwrite any files that already exist.'"`
+	Pad      string `kong:"flag,name='pad',enum='none,pow2,padme,random',default='none',help='Hide stream size with padding: none, pow2 to the next power of two, padme to within 12%, or random.'"`
+	PadRange uint64 `kong:"flag,name='pad-range',default='1024',help='Largest amount of random padding in kilobytes.'"`
+}
+
+// createOutput opens the output file, or stdout for -, asking before overwriting
+// unless stdin is the input.
+func createOutput(output string, force bool, stdinUsed bool) (*os.File, error) {
+	if output == stdio {
+		return os.Stdout, nil
+	}
+	output = kong.ExpandPath(output)
+	if !force {
+		if err := ConfirmOverwrite(output, stdinUsed); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// finishOutput closes the output file and removes it if it was not completed.
+func finishOutput(out *os.File, err error) error {
+	if out == os.Stdout {
+		return err
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+func (c *sealTask) sealWriter(out io.Writer) (io.WriteCloser, error) {
+	padding := archiver.Padding{Policy: archiver.PaddingPolicies[c.Pad], Range: c.PadRange * 1024}
+	if padding.Policy == archiver.PadNone {
+		return archiver.NewSealWriter(out, c.Key)
+	}
+	return archiver.NewPaddedSealWriter(out, c.Key, padding)
+}
+
+func (c *sealTask) Run(ctx *kong.Context) error {
+	if c.Key == "" {
+		key, err := ReadKey(`Please enter public key (-k) to encrypt the stream:`)
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+	in := os.Stdin
+	if c.File != stdio {
+		f, err := os.Open(kong.ExpandPath(c.File))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	out, err := createOutput(c.Output, c.Force, c.File == stdio)
+	if err != nil {
+		return err
+	}
+	return finishOutput(out, func() error {
+		w, err := c.sealWriter(out)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(w, in); err != nil {
+			return fmt.Errorf(`could not encrypt %s: %w`, c.File, err)
+		}
+		return w.Close()
+	}())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+type statusTask struct {
+	Job    string `kong:"arg,optional,help='Show recent runs of this job, rather than the last run of every job.'"`
+	Config string `kong:"flag,name='config',short='c',env='SaneArchiverConfig',help='Job configuration file, ~/.config/sane-archiver/jobs.yaml by default.'"`
+	Last   int    `kong:"flag,name='last',short='n',default='10',help='Number of recent runs to show.'"`
+}
+
+const statusTime = `2006-01-02 15:04`
+
+// formatStatusTime formats a time that may be missing.
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return `-`
+	}
+	return t.Local().Format(statusTime)
+}
+
+// jobState tells whether the job is running right now, by trying to take its lock.
+func jobState(dir string, name string) string {
+	lock, err := lockJob(dir, name)
+	if errors.Is(err, errJobRunning) {
+		return `running`
+	} else if err != nil {
+		return `unknown`
+	}
+	lock.Close()
+	return `idle`
+}
+
+func (t *statusTask) Run(ctx *kong.Context) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	history, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+	if t.Job != `` {
+		fmt.Fprintln(out, "STARTED\tDURATION\tRESULT\tLOG\tERROR")
+		shown := 0
+		for i := len(history) - 1; i >= 0 && shown < t.Last; i-- {
+			r := history[i]
+			if r.Job != t.Job {
+				continue
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", formatStatusTime(r.Started),
+				r.Finished.Sub(r.Started).Round(time.Second), r.Status, r.Log, r.Error)
+			shown++
+		}
+		if shown == 0 {
+			return fmt.Errorf(`job %q has not run yet`, t.Job)
+		}
+		return nil
+	}
+
+	// jobs of the configuration come first, then jobs that were removed from it
+	names := make([]string, 0)
+	schedules := make(map[string]*cronSchedule)
+	if c, err := loadJobConfig(configPath(t.Config)); err == nil {
+		names = c.names()
+		for _, name := range names {
+			if schedules[name], err = c.schedule(name); err != nil {
+				slog.Warn(`Schedule is not valid`, `job`, name, `error`, err)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.Warn(`Schedules are not shown`, `error`, err)
+	}
+	last, succeeded := make(map[string]jobResult), make(map[string]time.Time)
+	for _, r := range history {
+		if !contains(names, r.Job) {
+			names = append(names, r.Job)
+		}
+		last[r.Job] = r
+		if r.Status == jobSucceeded || r.Status == jobWarnings {
+			succeeded[r.Job] = r.Finished
+		}
+	}
+	fmt.Fprintln(out, "JOB\tSTATE\tLAST RUN\tRESULT\tLAST SUCCESS\tNEXT RUN")
+	now := time.Now()
+	for _, name := range names {
+		r, ran := last[name]
+		result := `-`
+		if ran {
+			result = r.Status
+		}
+		next := `-`
+		if s := schedules[name]; s != nil {
+			next = formatStatusTime(s.Next(now))
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", name, jobState(dir, name),
+			formatStatusTime(r.Started), result, formatStatusTime(succeeded[name]), next)
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archiver"
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// splitNull is a bufio.SplitFunc for lists separated by NUL characters, as printed by find -print0.
+func splitNull(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// readTargets reads a list of paths, one per line or separated by NUL characters.
+func readTargets(in io.Reader, null bool) ([]string, error) {
+	scanner := bufio.NewScanner(in)
+	if null {
+		scanner.Split(splitNull)
+	}
+	targets := make([]string, 0)
+	for scanner.Scan() {
+		target := scanner.Text()
+		if !null {
+			target = strings.TrimSuffix(target, "\r")
+		}
+		if target != `` {
+			targets = append(targets, target)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// readTargetsFrom reads a list of paths from a file, or from stdin if the file is "-".
+func readTargetsFrom(file string, null bool) ([]string, error) {
+	if file == stdio {
+		return readTargets(os.Stdin, null)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTargets(f, null)
+}
+
+// stdio stands for stdin or stdout in place of a path.
+const stdio = `-`
+
+// displayTarget hides credentials of an archive URL, so that it can be printed or logged.
+// Local paths are shown as they are.
+func displayTarget(target string) string {
+	if archiver.IsRemote(target) {
+		return archiver.RedactURL(target)
+	}
+	return target
+}
+
+// dedupTargets drops targets that were already given or lie within another target,
+// so that nothing is archived twice. The order of the remaining targets is kept.
+func dedupTargets(targets []string) []string {
+	absolute := make(map[string]string, len(targets))
+	for _, target := range targets {
+		p, err := filepath.Abs(target)
+		if err != nil {
+			p = filepath.Clean(target)
+		}
+		if _, ok := absolute[p]; !ok {
+			absolute[p] = target
+		}
+	}
+	unique := make([]string, 0, len(absolute))
+	kept := make(map[string]bool, len(absolute))
+targets:
+	for _, target := range targets {
+		if target == stdio {
+			if !kept[stdio] {
+				kept[stdio] = true
+				unique = append(unique, target)
+			}
+			continue
+		}
+		p, err := filepath.Abs(target)
+		if err != nil {
+			p = filepath.Clean(target)
+		}
+		if kept[p] || absolute[p] != target {
+			slog.Info(`Skipping target that was already given`, `target`, target)
+			continue
+		}
+		for child, parent := p, filepath.Dir(p); parent != child; child, parent = parent, filepath.Dir(parent) {
+			if covered, ok := absolute[parent]; ok {
+				slog.Info(`Skipping target within another target`, `target`, target, `within`, covered)
+				continue targets
+			}
+		}
+		kept[p] = true
+		unique = append(unique, target)
+	}
+	return unique
+}
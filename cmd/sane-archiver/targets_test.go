@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadTargets(t *testing.T) {
+	lines, err := readTargets(strings.NewReader("a\r\n\nb c\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{`a`, `b c`}) {
+		t.Errorf(`unexpected newline-delimited targets: %q`, lines)
+	}
+	nulls, err := readTargets(strings.NewReader("a\nb\x00c\x00"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nulls, []string{"a\nb", `c`}) {
+		t.Errorf(`unexpected NUL-delimited targets: %q`, nulls)
+	}
+}
+
+func TestDedupTargets(t *testing.T) {
+	targets := dedupTargets([]string{`/data/photos/2020`, `/data/docs`, `/data/photos`, `/data/docs/`, `/data/photos2`})
+	if !reflect.DeepEqual(targets, []string{`/data/docs`, `/data/photos`, `/data/photos2`}) {
+		t.Errorf(`unexpected targets: %q`, targets)
+	}
+}
+
+func TestDisplayTarget(t *testing.T) {
+	for target, expected := range map[string]string{
+		`s3://id:secret@us-east-1/bucket/backup.sane1`:    `s3://***@us-east-1/bucket/backup.sane1`,
+		`s3://id:sec%zzret@us-east-1/bucket/backup.sane1`: `s3://***@us-east-1/bucket/backup.sane1`,
+		`backups/100%zz.sane1`:                            `backups/100%zz.sane1`,
+	} {
+		if shown := displayTarget(target); shown != expected {
+			t.Errorf(`expected %q to be shown as %q, got %q`, target, expected, shown)
+		}
+	}
+}
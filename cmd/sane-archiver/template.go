@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templateValues fill in the placeholders of an output name template.
+type templateValues struct {
+	Time   time.Time // Only the date, hour and minute are encoded.
+	Host   string
+	Target string // Base name of the first packed target.
+	Hash   string // MD5 of the archive in hex.
+	Random string
+}
+
+// templateField is a placeholder of output name templates. The same definition renders
+// a value into a name and matches it when the name is parsed back.
+type templateField struct {
+	pattern string
+	render  func(v *templateValues) string
+	parse   func(v *templateValues, match string, date []int)
+}
+
+// dateField renders one part of the date zero-padded. Unpadded values, which
+// older versions wrote, are still recognized.
+func dateField(pattern string, part int, value func(t time.Time) int) templateField {
+	return templateField{
+		pattern: pattern,
+		render:  func(v *templateValues) string { return fmt.Sprintf(`%02d`, value(v.Time)) },
+		parse: func(v *templateValues, match string, date []int) {
+			date[part], _ = strconv.Atoi(match)
+		},
+	}
+}
+
+// textField renders a name that can be parsed back.
+func textField(pattern string, value func(v *templateValues) *string) templateField {
+	return templateField{
+		pattern: pattern,
+		render:  func(v *templateValues) string { return *value(v) },
+		parse:   func(v *templateValues, match string, date []int) { *value(v) = match },
+	}
+}
+
+const (
+	datePartYear = iota
+	datePartMonth
+	datePartDay
+	datePartHour
+	datePartMinute
+)
+
+var templateFields = map[string]templateField{
+	`year`:   dateField(`\d{4}`, datePartYear, func(t time.Time) int { return t.Year() }),
+	`month`:  dateField(`[01]?\d`, datePartMonth, func(t time.Time) int { return int(t.Month()) }),
+	`day`:    dateField(`[0123]?\d`, datePartDay, func(t time.Time) int { return t.Day() }),
+	`hour`:   dateField(`[012]?\d`, datePartHour, func(t time.Time) int { return t.Hour() }),
+	`minute`: dateField(`[0-5]?\d`, datePartMinute, func(t time.Time) int { return t.Minute() }),
+	`host`:   textField(`[\w.-]+`, func(v *templateValues) *string { return &v.Host }),
+	`target`: textField(`[\w.-]+`, func(v *templateValues) *string { return &v.Target }),
+	`random`: textField(`[0-9a-f]{8}`, func(v *templateValues) *string { return &v.Random }),
+	`hash`:   textField(`[0-9a-fA-F]{8,}`, func(v *templateValues) *string { return &v.Hash }),
+	`md5`:    textField(`[0-9a-fA-F]{8,}`, func(v *templateValues) *string { return &v.Hash }), // Older name of {hash}.
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{(\w*)\}`)
+
+// unsafeNameCharacters are replaced in host and target names, so that they fit into a file name.
+var unsafeNameCharacters = regexp.MustCompile(`[^\w.-]+`)
+
+// errTemplateMismatch indicates that a name was not rendered from the template.
+var errTemplateMismatch = errors.New(`name does not match the template`)
+
+// outputTemplate names archives, such as {year}-{month}-{day}-{hash}.sane1.
+type outputTemplate struct {
+	text   string
+	fields []string // in order of appearance, repeated placeholders only once
+	filter *regexp.Regexp
+}
+
+// parseTemplate checks that every placeholder of the template is known.
+func parseTemplate(text string) (*outputTemplate, error) {
+	t := &outputTemplate{text: text}
+	seen := make(map[string]bool)
+	expression := &strings.Builder{}
+	expression.WriteString(`^`)
+	last := 0
+	for _, at := range templatePlaceholder.FindAllStringSubmatchIndex(text, -1) {
+		name := text[at[2]:at[3]]
+		field, ok := templateFields[name]
+		if !ok {
+			return nil, fmt.Errorf(`output template <%s> has an unknown placeholder {%s}`, text, name)
+		}
+		expression.WriteString(regexp.QuoteMeta(text[last:at[0]]))
+		if seen[name] {
+			expression.WriteString(`(?:` + field.pattern + `)`)
+		} else {
+			expression.WriteString(`(` + field.pattern + `)`)
+			t.fields = append(t.fields, name)
+			seen[name] = true
+		}
+		last = at[1]
+	}
+	expression.WriteString(regexp.QuoteMeta(text[last:]) + `$`)
+	t.filter = regexp.MustCompile(expression.String())
+	return t, nil
+}
+
+// Has is true if the template contains the placeholder.
+func (t *outputTemplate) Has(name string) bool {
+	for _, field := range t.fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Render fills in every placeholder.
+func (t *outputTemplate) Render(v templateValues) string {
+	v.Host = unsafeNameCharacters.ReplaceAllString(v.Host, `_`)
+	v.Target = unsafeNameCharacters.ReplaceAllString(v.Target, `_`)
+	if v.Random == `` && t.Has(`random`) {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		v.Random = hex.EncodeToString(b)
+	}
+	return templatePlaceholder.ReplaceAllStringFunc(t.text, func(placeholder string) string {
+		return templateFields[placeholder[1:len(placeholder)-1]].render(&v)
+	})
+}
+
+// Narrow fills in {host} and {target}, so that the template only matches names of
+// archives made from the same host and target. Other placeholders are kept.
+func (t *outputTemplate) Narrow(v templateValues) *outputTemplate {
+	v.Host = unsafeNameCharacters.ReplaceAllString(v.Host, `_`)
+	v.Target = unsafeNameCharacters.ReplaceAllString(v.Target, `_`)
+	text := templatePlaceholder.ReplaceAllStringFunc(t.text, func(placeholder string) string {
+		switch name := placeholder[1 : len(placeholder)-1]; name {
+		case `host`, `target`:
+			return templateFields[name].render(&v)
+		}
+		return placeholder
+	})
+	narrowed, err := parseTemplate(text)
+	if err != nil {
+		panic(err) // the remaining placeholders were already parsed
+	}
+	return narrowed
+}
+
+// Parse recovers the values a name was rendered from. Parts of the date missing
+// from the template are set to their beginning, such as the first day of the month.
+// Where neighbouring names could be split in several ways, the first one takes the
+// longest share, so that a dash in {host} does not spill into {target}.
+func (t *outputTemplate) Parse(name string) (templateValues, error) {
+	v := templateValues{}
+	match := t.filter.FindStringSubmatch(name)
+	if match == nil {
+		return v, errTemplateMismatch
+	}
+	date := []int{1, 1, 1, 0, 0}
+	for i, field := range t.fields {
+		templateFields[field].parse(&v, match[i+1], date)
+	}
+	v.Time = time.Date(date[datePartYear], time.Month(date[datePartMonth]), date[datePartDay],
+		date[datePartHour], date[datePartMinute], 0, 0, time.Local)
+	if int(v.Time.Month()) != date[datePartMonth] || v.Time.Day() != date[datePartDay] ||
+		v.Time.Hour() != date[datePartHour] || v.Time.Minute() != date[datePartMinute] {
+		return v, fmt.Errorf(`name <%s> does not encode a valid date`, name)
+	}
+	return v, nil
+}
+
+func (t *outputTemplate) String() string {
+	return t.text
+}
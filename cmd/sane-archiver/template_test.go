@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutputTemplate(t *testing.T) {
+	v := templateValues{
+		Time:   time.Date(2026, time.March, 7, 4, 5, 0, 0, time.Local),
+		Host:   `db-1.example.com`,
+		Target: `photos`,
+		Hash:   `0123456789abcdef0123456789abcdef`,
+	}
+	cases := []struct {
+		template string
+		name     string
+	}{
+		{defaultOutputName, `2026-03-07-0123456789abcdef0123456789abcdef.sane1`},
+		{`{host}-{target}-{year}{month}{day}T{hour}{minute}.sane1`, `db-1.example.com-photos-20260307T0405.sane1`},
+		{`{year}/{year}-{md5}.zip`, `2026/2026-0123456789abcdef0123456789abcdef.zip`},
+	}
+	for _, c := range cases {
+		template, err := parseTemplate(c.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := template.Render(v)
+		if name != c.name {
+			t.Fatalf(`template %s rendered %s instead of %s`, c.template, name, c.name)
+		}
+		parsed, err := template.Parse(name)
+		if err != nil {
+			t.Fatalf(`template %s did not parse %s: %s`, c.template, name, err)
+		}
+		if template.Has(`hour`) && !parsed.Time.Equal(v.Time) {
+			t.Fatalf(`template %s parsed time %s from %s`, c.template, parsed.Time, name)
+		}
+		if template.Has(`host`) && (parsed.Host != v.Host || parsed.Target != v.Target) {
+			t.Fatalf(`template %s parsed host %s and target %s from %s`, c.template, parsed.Host, parsed.Target, name)
+		}
+		if (template.Has(`hash`) || template.Has(`md5`)) && parsed.Hash != v.Hash || parsed.Time.Year() != 2026 {
+			t.Fatalf(`template %s parsed %+v from %s`, c.template, parsed, name)
+		}
+	}
+
+	template, _ := parseTemplate(`{target}-{random}.sane1`)
+	random := template.Render(templateValues{Target: `my photos/2026`})
+	if parsed, err := template.Parse(random); err != nil || parsed.Target != `my_photos_2026` || len(parsed.Random) != 8 {
+		t.Fatalf(`unsafe target was not replaced in %s`, random)
+	}
+	template, _ = parseTemplate(defaultOutputName)
+	if parsed, err := template.Parse(`2020-1-9-0123456789.sane1`); err != nil || parsed.Time.Day() != 9 {
+		t.Fatal(`unpadded names of older versions were not parsed`)
+	}
+	if _, err := template.Parse(`2020-02-31-0123456789.sane1`); err == nil || err == errTemplateMismatch {
+		t.Fatal(`invalid date was not reported`)
+	}
+	if _, err := template.Parse(`notes.txt`); err != errTemplateMismatch {
+		t.Fatal(`unrelated name was matched`)
+	}
+	if _, err := parseTemplate(`{yaer}.sane1`); err == nil {
+		t.Fatal(`unknown placeholder was accepted`)
+	}
+}
@@ -1,34 +1,118 @@
 package main
 
 import (
+	"archive/zip"
 	"archiver"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"syscall"
 
 	"github.com/alecthomas/kong"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 type unpackTask struct {
 	Key    string   `kong:"flag,help='Private base64-encoded key.'"`
-	File   []string `kong:"arg,required,help='File to unpack.',type='existingfile',sep=' '"`
-	Output string   `kong:"flag,name='output',short='o',type='path',help='Output directory.',default='.'"`
+	File   []string `kong:"arg,required,help='File or s3:// URL to unpack, or - for stdin when writing to stdout.',sep=' '"`
+	Output string   `kong:"flag,name='output',short='o',help='Output directory, or - to stream decrypted contents to stdout.',default='.'"`
 	Force  bool     `kong:"flag,name='force',short='f',help='Overwrite any files that already exist.'"`
+	Git    string   `kong:"flag,name='restore-git',type='path',help='Clone git bundles found in the archive into working repositories under this directory.'"`
+	Into   string   `kong:"flag,name='extract',short='e',type='path',help='Also extract decrypted archive into this directory, restoring file metadata.'"`
+}
+
+// restoreGitBundles clones every git bundle stored in the unpacked archive.
+func restoreGitBundles(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = readEntries(f, info.Size(), func(e archiveEntry) error {
+		if !strings.HasSuffix(e.Name, `.bundle`) {
+			return nil
+		}
+		// keep repositories within the directory even if the entry name is hostile
+		target := filepath.Join(dir, filepath.Clean(`/`+strings.TrimSuffix(e.Name, `.bundle`)))
+		r, err := e.Open()
+		if err != nil {
+			return err
+		}
+		err = archiver.RestoreGitBundle(r, target)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf(`could not restore git bundle %s: %w`, e.Name, err)
+		}
+		slog.Info(`Git repository restored`, `path`, target)
+		return nil
+	})
+	return err
+}
+
+// extract unpacks the decrypted archive into the directory.
+func extract(archive string, format archiver.Format, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if format == archiver.FormatZip {
+		z, err := zip.OpenReader(archive)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		return archiver.ExtractZip(&z.Reader, dir)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return archiver.ExtractTar(f, dir)
+}
+
+// stream writes decrypted contents of a single archive to stdout.
+func (c *unpackTask) stream() error {
+	if len(c.File) != 1 {
+		return fmt.Errorf(`only one archive can be streamed to stdout at a time`)
+	}
+	if c.Into != `` || c.Git != `` {
+		return fmt.Errorf(`archive streamed to stdout cannot be extracted`)
+	}
+	arg := c.File[0]
+	if arg != stdio {
+		if err := archiver.DecodeTo(os.Stdout, arg, c.Key); err != nil {
+			return fmt.Errorf("could not decrypt file <%s>: %w", displayTarget(arg), err)
+		}
+		return nil
+	}
+	r, err := archiver.NewSaneReader(os.Stdin, c.Key)
+	if err != nil {
+		return fmt.Errorf("could not decrypt stdin: %w", err)
+	}
+	if _, err = io.Copy(os.Stdout, r); err != nil {
+		return fmt.Errorf("could not decrypt stdin: %w", err)
+	}
+	return nil
 }
 
 func (c *unpackTask) Run(ctx *kong.Context) error {
 	if c.Key == "" {
-		fmt.Println(`Please enter private key (-k) to decrypt target archives:`)
-		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+		key, err := ReadKey(`Please enter private key (-k) to decrypt target archives:`)
 		if err != nil {
 			return err
 		}
-		c.Key = strings.TrimSpace(string(bytePassword))
+		c.Key = key
+	}
+	if c.Output == stdio {
+		return c.stream()
 	}
+	c.Output = kong.ExpandPath(c.Output)
 	info, err := os.Stat(c.Output)
 	if err != nil || (err == nil && !info.IsDir()) {
 		// TODO: should be able to take an output file!
@@ -37,13 +121,30 @@ func (c *unpackTask) Run(ctx *kong.Context) error {
 
 	var p string
 	for _, arg := range c.File {
-		p = path.Join(c.Output, strings.TrimSuffix(filepath.Base(arg), `.sane1`)+`.zip`)
+		format, compression, err := archiver.DetectArchiveFormat(arg, c.Key)
+		if err != nil {
+			return fmt.Errorf("could not decrypt file <%s>: %w", displayTarget(arg), err)
+		}
+		p = path.Join(c.Output, strings.TrimSuffix(filepath.Base(arg), `.sane1`)+format.Extension(compression))
 		if !c.Force {
-			ConfirmOverwrite(p)
+			if err = ConfirmOverwrite(p, false); err != nil {
+				return err
+			}
 		}
-		err := archiver.Decode(p, arg, c.Key)
+		err = archiver.Decode(p, arg, c.Key)
 		if err != nil {
-			return fmt.Errorf("could not decrypt file <%s>: %w", arg, err)
+			return fmt.Errorf("could not decrypt file <%s>: %w", displayTarget(arg), err)
+		}
+		if c.Into != `` {
+			if err = extract(p, format, c.Into); err != nil {
+				return fmt.Errorf("could not extract file <%s>: %w", p, err)
+			}
+			slog.Info(`Archive extracted`, `path`, c.Into)
+		}
+		if c.Git != `` {
+			if err = restoreGitBundles(p, c.Git); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+
+	"github.com/alecthomas/kong"
+)
+
+type verifyTask struct {
+	Key  string   `kong:"flag,help='Private base64-encoded key.'"`
+	File []string `kong:"arg,required,help='File or s3:// URL to verify.',sep=' '"`
+}
+
+// verify reads every archived file, which checks it against its stored checksum.
+func verify(target string, key string) error {
+	r, size, in, err := openArchive(target, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	files := 0
+	_, err = readEntries(r, size, func(e archiveEntry) error {
+		f, err := e.Open()
+		if err != nil {
+			return fmt.Errorf(`%s: %w`, e.Name, err)
+		}
+		_, err = io.Copy(ioutil.Discard, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf(`%s: %w`, e.Name, err)
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info(`Archive is intact`, `path`, displayTarget(target), `files`, files)
+	return nil
+}
+
+func (c *verifyTask) Run(ctx *kong.Context) error {
+	if c.Key == "" {
+		key, err := ReadKey(`Please enter private key (-k) to verify target archives:`)
+		if err != nil {
+			return err
+		}
+		c.Key = key
+	}
+	for _, arg := range c.File {
+		if err := verify(arg, c.Key); err != nil {
+			return fmt.Errorf("file <%s> is damaged: %w", displayTarget(arg), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// commandErrorTail is how much of the standard error of a failed command is kept for its error message.
+const commandErrorTail = 1024
+
+// CommandSource is a command whose standard output is archived as an entry,
+// such as a database dump made by pg_dump or mysqldump.
+type CommandSource struct {
+	Name    string // Archive entry name.
+	Command string // Shell command line.
+}
+
+func (s CommandSource) String() string {
+	return s.Name + `=` + s.Command
+}
+
+// ParseCommandSource reads a source written as name=command.
+func ParseCommandSource(definition string) (CommandSource, error) {
+	parts := strings.SplitN(definition, `=`, 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == `` || strings.TrimSpace(parts[1]) == `` {
+		return CommandSource{}, fmt.Errorf(`command source %q must be written as name=command`, definition)
+	}
+	return CommandSource{Name: strings.TrimSpace(parts[0]), Command: parts[1]}, nil
+}
+
+// tailWriter keeps only the last bytes written into it.
+type tailWriter struct {
+	bytes.Buffer
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n, _ := w.Buffer.Write(p)
+	if extra := w.Len() - commandErrorTail; extra > 0 {
+		w.Next(extra)
+	}
+	return n, nil
+}
+
+// run executes the command through the shell, writing its output.
+func (s CommandSource) run(out io.Writer) error {
+	stderr := &tailWriter{}
+	cmd := exec.Command(`sh`, `-c`, s.Command)
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != `` {
+			return fmt.Errorf(`command %q failed: %w: %s`, s.Command, err, message)
+		}
+		return fmt.Errorf(`command %q failed: %w`, s.Command, err)
+	}
+	return nil
+}
+
+// AddCommand runs the command and stores its output as an archive entry. The output is spooled
+// first, so that a command exiting with an error leaves no partial entry in the archive.
+func (w *SaneWriter) AddCommand(source CommandSource) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	s, err := newSpool()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	slog.Info(`Running command`, `command`, source.Command, `name`, source.Name)
+	if err = source.run(s); err != nil {
+		return err
+	}
+	if w.Format == FormatTar {
+		return w.addTarBuffer(unrootPath.ReplaceAllString(path.Clean(filepath.ToSlash(source.Name)), ""), s)
+	}
+	r, err := s.Reader()
+	if err != nil {
+		return err
+	}
+	return w.AddReader(source.Name, &r)
+}
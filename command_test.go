@@ -0,0 +1,58 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandSource(t *testing.T) {
+	s, err := ParseCommandSource(`db.sql=pg_dump --dbname=app`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != `db.sql` || s.Command != `pg_dump --dbname=app` {
+		t.Errorf(`unexpected source: %+v`, s)
+	}
+	for _, definition := range []string{`db.sql`, `=pg_dump`, `db.sql=`} {
+		if _, err = ParseCommandSource(definition); err == nil {
+			t.Errorf(`%q must not be accepted`, definition)
+		}
+	}
+}
+
+func TestAddCommand(t *testing.T) {
+	var b bytes.Buffer
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: &b}
+	if err := w.AddCommand(CommandSource{`dump.txt`, `echo dumped`}); err != nil {
+		t.Fatal(err)
+	}
+	err := w.AddCommand(CommandSource{`broken.txt`, `echo partial; echo refused >&2; exit 2`})
+	if err == nil || !strings.Contains(err.Error(), `refused`) {
+		t.Fatalf(`expected the failure to carry standard error, got %v`, err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, size, err := NewSaneReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()), testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(z.File) != 1 || z.File[0].Name != `dump.txt` {
+		t.Fatalf(`expected only the successful command to be stored, got %d entries`, len(z.File))
+	}
+	in, err := z.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if content, _ := ioutil.ReadAll(in); string(content) != "dumped\n" {
+		t.Errorf(`unexpected output: %q`, content)
+	}
+}
@@ -0,0 +1,202 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the method used to compress archived files.
+type Compression uint8
+
+const (
+	// CompressionDeflate is understood by every zip and gzip tool.
+	CompressionDeflate Compression = iota
+	// CompressionStore keeps files as they are.
+	CompressionStore
+	// CompressionZstd compresses better and faster than deflate, but needs a modern reader.
+	CompressionZstd
+)
+
+// zipMethodZstd identifies zstd entries, as assigned by the zip specification.
+const zipMethodZstd = 93
+
+// Compressions maps compression names, as used on the command line, to methods.
+var Compressions = map[string]Compression{
+	`deflate`: CompressionDeflate,
+	`store`:   CompressionStore,
+	`zstd`:    CompressionZstd,
+}
+
+func (c Compression) String() string {
+	for name, method := range Compressions {
+		if method == c {
+			return name
+		}
+	}
+	return fmt.Sprintf(`compression-%d`, uint8(c))
+}
+
+func (c Compression) zipMethod() uint16 {
+	switch c {
+	case CompressionStore:
+		return zip.Store
+	case CompressionZstd:
+		return zipMethodZstd
+	}
+	return zip.Deflate
+}
+
+// ZipMethodName names the compression method of a zip entry.
+func ZipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return CompressionStore.String()
+	case zip.Deflate:
+		return CompressionDeflate.String()
+	case zipMethodZstd:
+		return CompressionZstd.String()
+	}
+	return fmt.Sprintf(`method-%d`, method)
+}
+
+// ValidateLevel checks that the compression level is within the range of the method.
+// Level 0 picks the default of each method.
+func (c Compression) ValidateLevel(level int) error {
+	switch {
+	case level == 0:
+		return nil
+	case c == CompressionDeflate && level >= flate.BestSpeed && level <= flate.BestCompression:
+		return nil
+	case c == CompressionZstd && level >= 1 && level <= 22:
+		return nil
+	}
+	return fmt.Errorf(`compression level %d is not supported by %s`, level, c)
+}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func init() {
+	zip.RegisterDecompressor(zipMethodZstd, func(r io.Reader) io.ReadCloser {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return ioutil.NopCloser(&errorReader{err})
+		}
+		return d.IOReadCloser()
+	})
+}
+
+type errorReader struct{ err error }
+
+func (r *errorReader) Read(p []byte) (int, error) { return 0, r.err }
+
+// zipCompressor creates compressors of the method at the level for zip entries.
+func zipCompressor(c Compression, level int) zip.Compressor {
+	if c == CompressionZstd {
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return newZstdWriter(w, level, 1)
+		}
+	}
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	}
+}
+
+func newZstdWriter(w io.Writer, level int, jobs int) (*zstd.Encoder, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	options := []zstd.EOption{zstd.WithEncoderConcurrency(jobs)}
+	if level != 0 {
+		options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return zstd.NewWriter(w, options...)
+}
+
+// compressStream wraps a tar stream. Stored tar streams still use gzip framing
+// without compression, so that the format can always be recognized. Only zstd
+// spreads the work of a single stream across several jobs.
+func compressStream(w io.Writer, c Compression, level int, jobs int) (io.WriteCloser, error) {
+	switch c {
+	case CompressionZstd:
+		return newZstdWriter(w, level, jobs)
+	case CompressionStore:
+		return gzip.NewWriterLevel(w, gzip.NoCompression)
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// Decompress unwraps a gzip or zstd compressed tar stream.
+func Decompress(in io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(in)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	if bytes.HasPrefix(magic, zstdMagic) {
+		d, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return gzip.NewReader(buffered)
+}
+
+// compressedExtensions are kinds of files that gain nothing from another round of compression.
+var compressedExtensions = map[string]bool{
+	`.7z`: true, `.apk`: true, `.avi`: true, `.br`: true, `.bz2`: true, `.deb`: true, `.docx`: true,
+	`.epub`: true, `.flac`: true, `.gif`: true, `.gz`: true, `.heic`: true, `.jar`: true, `.jpeg`: true,
+	`.jpg`: true, `.lz4`: true, `.m4a`: true, `.mkv`: true, `.mov`: true, `.mp3`: true, `.mp4`: true,
+	`.odt`: true, `.ogg`: true, `.opus`: true, `.png`: true, `.pptx`: true, `.rar`: true, `.rpm`: true,
+	`.sane1`: true, `.tgz`: true, `.webm`: true, `.webp`: true, `.woff2`: true, `.xlsx`: true, `.xz`: true,
+	`.zip`: true, `.zst`: true,
+}
+
+// entropySampleSize is how much of a file is measured to guess whether it is already compressed.
+const entropySampleSize = 64 * 1024
+
+// highEntropy is bits per byte above which a sample looks compressed or encrypted.
+const highEntropy = 7.5
+
+// entropy measures the Shannon entropy of the sample in bits per byte.
+func entropy(sample []byte) float64 {
+	if len(sample) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range sample {
+		counts[b]++
+	}
+	total, e := float64(len(sample)), 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / total
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// isIncompressible guesses whether compressing a file would be wasted effort,
+// judging by its extension or by the entropy of its beginning.
+func isIncompressible(name string, sample []byte) bool {
+	if compressedExtensions[strings.ToLower(filepath.Ext(name))] {
+		return true
+	}
+	// short samples always look random
+	return len(sample) >= 4096 && entropy(sample) > highEntropy
+}
@@ -0,0 +1,82 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestIsIncompressible(t *testing.T) {
+	noise := make([]byte, entropySampleSize)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatal(err)
+	}
+	text := []byte(strings.Repeat(`sane archiver keeps files `, 3000))
+	for _, c := range []struct {
+		name   string
+		sample []byte
+		want   bool
+	}{
+		{`notes.txt`, text, false},
+		{`photo.JPG`, text, true},
+		{`dump.bin`, noise, true},
+		{`short.bin`, noise[:100], false},
+	} {
+		if got := isIncompressible(c.name, c.sample); got != c.want {
+			t.Errorf(`%s: expected %v, got %v`, c.name, c.want, got)
+		}
+	}
+}
+
+func TestCompressionMethods(t *testing.T) {
+	noise := make([]byte, entropySampleSize)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat(`sane archiver keeps files `, 3000)
+	for _, c := range []Compression{CompressionDeflate, CompressionStore, CompressionZstd} {
+		var b bytes.Buffer
+		w := &SaneWriter{PublicKey: testPublicKey, Writer: &b, Compression: c}
+		var r io.Reader = strings.NewReader(text)
+		if err := w.AddReader(`text.txt`, &r); err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(noise)
+		if err := w.AddReader(`noise.bin`, &r); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ra, size, err := NewSaneReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()), testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := zip.NewReader(ra, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]uint16{`text.txt`: c.zipMethod(), `noise.bin`: zip.Store}
+		for _, f := range z.File {
+			if f.Method != want[f.Name] {
+				t.Errorf(`%s: %s entry stored with %s`, c, f.Name, ZipMethodName(f.Method))
+			}
+			in, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(in)
+			in.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Name == `text.txt` && string(content) != text {
+				t.Errorf(`%s: %s was not restored`, c, f.Name)
+			}
+		}
+	}
+}
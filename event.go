@@ -0,0 +1,52 @@
+package archiver
+
+// Event is something that happened while an archive was made or uploaded: FileAdded,
+// PathSkipped, GitReferenceArchived or UploadDone. Programs embedding the archiver
+// receive events through an EventHandler rather than by parsing the log.
+type Event interface {
+	event()
+}
+
+// EventHandler receives events as they happen. It is called from several goroutines
+// when files are compressed or uploaded concurrently, so it must be safe for concurrent use.
+// Calling a nil EventHandler does nothing.
+type EventHandler func(Event)
+
+func (h EventHandler) emit(e Event) {
+	if h != nil {
+		h(e)
+	}
+}
+
+// FileAdded reports an entry stored in the archive, either a file or a stream.
+type FileAdded struct {
+	Path string // File path, or the entry name of a stream.
+	Size int64  // Bytes read, before compression.
+}
+
+// PathSkipped reports a path left out of the archive, because it matched an ignore
+// rule or because of an error under a policy other than ErrorAbort.
+type PathSkipped struct {
+	Path   string
+	Reason string // Why the path was ignored, if Err is nil.
+	Err    error
+}
+
+// GitReferenceArchived reports a branch or tag of a git repository stored in the archive.
+type GitReferenceArchived struct {
+	Repository string
+	Reference  GitReference
+	Bundle     bool // Stored within a bundle of the whole repository.
+}
+
+// UploadDone reports a finished upload, successful unless Err is set.
+type UploadDone struct {
+	File string
+	URL  string // Destination with credentials redacted.
+	Err  error
+}
+
+func (FileAdded) event()            {}
+func (PathSkipped) event()          {}
+func (GitReferenceArchived) event() {}
+func (UploadDone) event()           {}
@@ -0,0 +1,81 @@
+package archiver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testEvents collects events of a walk, which may arrive from several goroutines.
+func testEvents(t *testing.T, d *SaneDirectoryWalker, jobs int) []Event {
+	var mu sync.Mutex
+	events := make([]Event, 0)
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: ioutil.Discard, Jobs: jobs, Events: func(e Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}}
+	if err := d.Walk(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-events-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{`a.txt`: `a`, `b.txt`: `bb`, `skip.log`: `ignored`} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink(filepath.Join(dir, `missing`), filepath.Join(dir, `broken`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, jobs := range []int{1, 4} {
+		added, ignored, failed := int64(0), 0, 0
+		for _, e := range testEvents(t, &SaneDirectoryWalker{
+			Target: dir,
+			Ignore: IgnoreRules{Exclude: []string{`*.log`}},
+			Policy: ErrorSkip,
+		}, jobs) {
+			switch e := e.(type) {
+			case FileAdded:
+				added += e.Size
+			case PathSkipped:
+				if e.Err != nil && e.Path == filepath.Join(dir, `broken`) {
+					failed++
+				} else if e.Err == nil && e.Path == filepath.Join(dir, `skip.log`) && e.Reason != `` {
+					ignored++
+				}
+			default:
+				t.Fatalf(`unexpected event %#v`, e)
+			}
+		}
+		if added != 3 || ignored != 1 || failed != 1 {
+			t.Fatalf(`with %d jobs, %d bytes were added, %d paths ignored and %d failed`, jobs, added, ignored, failed)
+		}
+	}
+
+	repository, branch := testGitRepository(t)
+	for _, bundle := range []bool{false, true} {
+		found := false
+		for _, e := range testEvents(t, &SaneDirectoryWalker{Target: repository, Bundle: bundle}, 1) {
+			if e, ok := e.(GitReferenceArchived); ok && e.Reference.Name == branch {
+				found = e.Repository == repository && e.Bundle == bundle
+			}
+		}
+		if !found {
+			t.Fatalf(`branch %q was not reported, bundle %t`, branch, bundle)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractPath places an archive entry within the directory even if the entry name is hostile,
+// and refuses entries that would be written through a symbolic link leading outside of it.
+func extractPath(dir string, name string) (string, error) {
+	target := filepath.Join(dir, filepath.Clean(`/`+name))
+	if err := checkWithin(dir, filepath.Dir(target)); err != nil {
+		return ``, fmt.Errorf(`entry %s: %w`, name, err)
+	}
+	return target, nil
+}
+
+// checkWithin resolves symbolic links of the nearest existing ancestor of the path, or the path
+// itself, and fails unless it stays within the directory. Missing ancestors are created by
+// extraction later, so the links found now are the only ones they can be reached through.
+func checkWithin(dir string, path string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if os.IsNotExist(err) && path != dir {
+			path = filepath.Dir(path)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || rel == `..` || strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			return fmt.Errorf(`%s leads outside of <%s>`, path, dir)
+		}
+		return nil
+	}
+}
+
+// createFile replaces whatever is at path with a new file holding contents of the reader.
+func createFile(path string, in io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	os.Remove(path)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ExtractTar unpacks a gzip or zstd compressed tar archive into the directory, restoring
+// permissions, times, links, device nodes and extended attributes. Ownership is
+// restored only when running as root, otherwise files belong to the current user.
+// The manifest describes the archive rather than its contents, so it is left out.
+func ExtractTar(in io.Reader, dir string) error {
+	stream, err := Decompress(in)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	archive := tar.NewReader(stream)
+	owner := os.Geteuid() == 0
+	dirs := make([]*tar.Header, 0)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		} else if header.Name == ManifestName {
+			continue
+		}
+		target, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// an existing link must not let directory permissions apply outside
+			if err = checkWithin(dir, target); err != nil {
+				return err
+			}
+			if err = os.MkdirAll(target, 0700); err == nil {
+				dirs = append(dirs, header) // permissions are applied after contents are in place
+			}
+		case tar.TypeReg:
+			err = createFile(target, archive)
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(target), 0700); err == nil {
+				os.Remove(target)
+				err = os.Symlink(header.Linkname, target)
+			}
+		case tar.TypeLink:
+			var first string
+			if first, err = extractPath(dir, header.Linkname); err == nil {
+				os.Remove(target)
+				err = os.Link(first, target)
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if err = os.MkdirAll(filepath.Dir(target), 0700); err == nil {
+				os.Remove(target)
+				err = makeNode(target, header)
+			}
+		default:
+			slog.Warn(`Skipping entry of unsupported type`, `path`, header.Name, `type`, string(header.Typeflag))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf(`could not extract %s: %w`, header.Name, err)
+		}
+		if header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeLink {
+			if err = restoreMetadata(target, header, owner); err != nil {
+				return fmt.Errorf(`could not restore metadata of %s: %w`, header.Name, err)
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, _ := extractPath(dir, dirs[i].Name)
+		if err := restoreMetadata(target, dirs[i], owner); err != nil {
+			return fmt.Errorf(`could not restore metadata of %s: %w`, dirs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreMetadata applies ownership, extended attributes, permissions and times, in that
+// order, because changing ownership clears set-user-ID bits and attributes change ctime.
+func restoreMetadata(path string, header *tar.Header, owner bool) error {
+	if owner {
+		if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+			return err
+		}
+	}
+	for key, value := range header.PAXRecords {
+		if name := strings.TrimPrefix(key, `SCHILY.xattr.`); name != key {
+			if err := writeXattr(path, name, value); err != nil {
+				slog.Warn(`Extended attribute could not be restored`, `path`, path, `attribute`, name, `error`, err)
+			}
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return setSymlinkTime(path, header)
+	}
+	if err := os.Chmod(path, os.FileMode(header.Mode).Perm()|tarModeBits(header.Mode)); err != nil {
+		return err
+	}
+	return os.Chtimes(path, header.AccessTime, header.ModTime)
+}
+
+// tarModeBits converts set-user-ID, set-group-ID and sticky bits of a tar header.
+func tarModeBits(mode int64) (m os.FileMode) {
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// ExtractZip unpacks a zip archive into the directory, restoring permissions and modification times.
+// The manifest is left out, like in ExtractTar.
+func ExtractZip(archive *zip.Reader, dir string) error {
+	for _, f := range archive.File {
+		if f.Name == ManifestName {
+			continue
+		}
+		target, err := extractPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err = os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = createFile(target, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf(`could not extract %s: %w`, f.Name, err)
+		}
+		if err = os.Chmod(target, f.Mode().Perm()); err != nil {
+			return err
+		}
+		if err = os.Chtimes(target, f.Modified, f.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir(``, `sane-archiver-tar-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir(``, `sane-archiver-extract-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err = os.MkdirAll(filepath.Join(src, `empty`), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(src, `script.sh`), []byte(`#!/bin/sh`), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(`script.sh`, filepath.Join(src, `symlink`)); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Link(filepath.Join(src, `script.sh`), filepath.Join(src, `hardlink`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: &b, Format: FormatTar, Manifest: &Manifest{Host: `test`}}
+	if err = (&SaneDirectoryWalker{Target: src}).Walk(w); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewSaneReader(&b, testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ExtractTar(r, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dst, src)
+	if info, err := os.Stat(filepath.Join(root, `empty`)); err != nil || !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Fatalf(`empty directory was not restored: %v %v`, info, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, `script.sh`)); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf(`file permissions were not restored: %v %v`, info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(root, `symlink`)); err != nil || link != `script.sh` {
+		t.Fatalf(`symbolic link was not restored: %q %v`, link, err)
+	}
+	a, err := os.Stat(filepath.Join(root, `script.sh`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := os.Stat(filepath.Join(root, `hardlink`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, c) {
+		t.Fatal(`hard link was restored as a separate file`)
+	}
+	if _, err = os.Stat(filepath.Join(dst, ManifestName)); !os.IsNotExist(err) {
+		t.Fatalf(`manifest should not be extracted: %v`, err)
+	}
+}
+
+func TestExtractTarThroughSymlink(t *testing.T) {
+	dst, err := ioutil.TempDir(``, `sane-archiver-extract-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	archive := tar.NewWriter(z)
+	for _, header := range []*tar.Header{
+		{Typeflag: tar.TypeSymlink, Name: `a`, Linkname: `/etc`, Mode: 0777},
+		{Typeflag: tar.TypeReg, Name: `a/sub/x`, Size: 1, Mode: 0644},
+	} {
+		if err = archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err = archive.Write([]byte(`x`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ExtractTar(&b, dst); err == nil {
+		t.Error(`entry written through a symbolic link outside of the directory was not refused`)
+	}
+	if _, err = os.Lstat(`/etc/sub`); !os.IsNotExist(err) {
+		t.Fatalf(`entry was written outside of the directory: %v`, err)
+	}
+}
@@ -0,0 +1,54 @@
+package archiver
+
+import (
+	"bytes"
+)
+
+// Format is the layout of archive contents inside the encrypted stream.
+type Format uint8
+
+const (
+	// FormatZip compresses each file separately and keeps a directory of contents
+	// at the end, which allows listing archives without reading them fully.
+	FormatZip Format = iota
+	// FormatTar is a compressed PAX tar stream, which preserves ownership,
+	// links, device nodes, extended attributes and empty directories.
+	FormatTar
+)
+
+// Formats maps format names, as used on the command line, to formats.
+var Formats = map[string]Format{
+	`zip`: FormatZip,
+	`tar`: FormatTar,
+}
+
+// Extension is appended to the names of decrypted archives.
+// Tar streams are named after the compression that wraps them.
+func (f Format) Extension(c Compression) string {
+	switch {
+	case f == FormatTar && c == CompressionZstd:
+		return `.tar.zst`
+	case f == FormatTar:
+		return `.tar.gz`
+	}
+	return `.zip`
+}
+
+// DetectFormat tells the format of decrypted contents by their first bytes.
+// Contents that cannot be recognized are assumed to be a zip archive,
+// which was the only format before others were introduced.
+func DetectFormat(header []byte) Format {
+	if bytes.HasPrefix(header, []byte{0x1f, 0x8b}) || bytes.HasPrefix(header, zstdMagic) {
+		return FormatTar
+	}
+	return FormatZip
+}
+
+// DetectCompression tells which compression wraps a tar stream by its first bytes.
+// Zip archives compress each entry separately, so they are reported as deflate.
+func DetectCompression(header []byte) Compression {
+	if bytes.HasPrefix(header, zstdMagic) {
+		return CompressionZstd
+	}
+	return CompressionDeflate
+}
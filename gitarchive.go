@@ -3,47 +3,462 @@ package archiver
 // Implement git integration for smart archiving.
 
 import (
-	// "bytes"
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
-	// "log"
-	"fmt"
-	"io"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrNotGitRepository indicates that a directory does not hold a git repository.
+var ErrNotGitRepository = errors.New(`not a git repository`)
+
+// openGitRepository opens the repository stored exactly at path, without looking in parent directories.
+func openGitRepository(path string) (*git.Repository, error) {
+	r, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, fmt.Errorf(`path <%s>: %w`, path, ErrNotGitRepository)
+	}
+	return r, err
+}
+
+// GitRepositoryKind tells how a git repository is laid out on disk.
+type GitRepositoryKind uint8
+
+const (
+	// GitWorkingRepository keeps its data in the .git directory of its working tree.
+	GitWorkingRepository GitRepositoryKind = iota
+	// GitBareRepository has no working tree, its directory is usually named *.git.
+	GitBareRepository
+	// GitLinkedWorktree was added by `git worktree add` and shares data with its main repository.
+	GitLinkedWorktree
 )
 
-const gitCleanBranchList = `git branch | awk -F ' +' '! /\(no branch\)/ {print $2}'`
-const gitArchiveBranch = `git archive "%s"`
+func (k GitRepositoryKind) String() string {
+	switch k {
+	case GitBareRepository:
+		return `bare repository`
+	case GitLinkedWorktree:
+		return `linked worktree`
+	}
+	return `repository`
+}
+
+// DetectGitRepository tells what kind of git repository is stored at path.
+func DetectGitRepository(path string) (GitRepositoryKind, error) {
+	if _, err := openGitRepository(path); err != nil {
+		return GitWorkingRepository, err
+	}
+	dotGit := filepath.Join(path, git.GitDirName)
+	info, err := os.Lstat(dotGit)
+	if os.IsNotExist(err) {
+		return GitBareRepository, nil
+	} else if err != nil {
+		return GitWorkingRepository, err
+	} else if info.IsDir() {
+		return GitWorkingRepository, nil
+	}
+	// .git file points to the repository data, which for linked
+	// worktrees also holds a reference to the main repository
+	content, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return GitWorkingRepository, err
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(string(content), `gitdir:`))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(path, gitDir)
+	}
+	if _, err = os.Stat(filepath.Join(gitDir, `commondir`)); err == nil {
+		return GitLinkedWorktree, nil
+	}
+	return GitWorkingRepository, nil
+}
+
+// GitHeadBranch returns the branch checked out in the repository or an empty string
+// if HEAD is detached or the repository has no commits yet.
+func GitHeadBranch(path string) (string, error) {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return ``, err
+	}
+	head, err := r.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return ``, nil // HEAD points at a branch without commits
+	} else if err != nil {
+		return ``, err
+	}
+	if !head.Name().IsBranch() {
+		return ``, nil
+	}
+	return head.Name().Short(), nil
+}
 
 // GitBranchList lists all local git branches found in current directory.
 func GitBranchList(path string) ([]string, error) {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := r.Branches()
+	if err != nil {
+		return nil, err
+	}
 	result := make([]string, 0)
-	cmd := exec.Command("/bin/bash", "-c", gitCleanBranchList)
-	cmd.Dir = path
-	out, err := cmd.Output()
-	if err == nil {
-		for _, branch := range strings.Split(string(out), "\n") {
-			if len(branch) > 0 {
-				result = append(result, branch)
-				// log.Println(`adding`, branch, `|`)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		result = append(result, ref.Name().Short())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return result, fmt.Errorf(`git repository <%s> has no branches`, path)
+	}
+	return result, nil
+}
+
+// GitReferences lists local branches and tags of the repository.
+func GitReferences(path string) ([]GitReference, error) {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]GitReference, 0)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+			return nil
+		}
+		commit, err := gitCommit(r, ref.Hash())
+		if errors.Is(err, errNotCommit) && ref.Name().IsTag() {
+			slog.Warn(`Skipping git tag that does not point at a commit`, `repository`, path,
+				`tag`, ref.Name().Short(), `error`, err)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf(`%s: %w`, ref.Name(), err)
+		}
+		result = append(result, GitReference{
+			Name:    ref.Name().Short(),
+			Tag:     ref.Name().IsTag(),
+			Commit:  commit.Hash.String(),
+			Updated: commit.Committer.When,
+		})
+		return nil
+	})
+	return result, err
+}
+
+// errNotCommit indicates that a reference points at a tree or a blob, which tags may do.
+var errNotCommit = errors.New(`not a commit`)
+
+// gitCommit returns the commit at hash, looking through annotated tags.
+func gitCommit(r *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	o, err := r.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Type() {
+	case plumbing.CommitObject:
+		return object.DecodeCommit(r.Storer, o)
+	case plumbing.TagObject:
+		tag, err := object.DecodeTag(r.Storer, o)
+		if err != nil {
+			return nil, err
+		}
+		return gitCommit(r, tag.Target)
+	}
+	return nil, fmt.Errorf(`%w, but a %s`, errNotCommit, o.Type())
+}
+
+// GitArchiveReader streams the tree of the branch tip as a tar archive, like `git archive` does.
+// Any error encountered while reading the repository is returned by the reader.
+func GitArchiveReader(path string, branch string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeGitArchive(w, path, plumbing.NewBranchReferenceName(branch)))
+	}()
+	return r
+}
+
+// GitTagArchiveReader streams the tree of the tagged commit as a tar archive.
+func GitTagArchiveReader(path string, tag string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeGitArchive(w, path, plumbing.NewTagReferenceName(tag)))
+	}()
+	return r
+}
+
+func writeGitArchive(w io.Writer, path string, name plumbing.ReferenceName) error {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return err
+	}
+	ref, err := r.Reference(name, true)
+	if err != nil {
+		return fmt.Errorf(`%s: %w`, name, err)
+	}
+	commit, err := gitCommit(r, ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(w)
+	err = archive.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       `pax_global_header`,
+		PAXRecords: map[string]string{`comment`: commit.Hash.String()},
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeGitTree(archive, r, path, commit, ``); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeGitTree adds the commit tree to the archive under prefix, descending into
+// submodules at the commits recorded in the tree.
+func writeGitTree(archive *tar.Writer, r *git.Repository, path string, commit *object.Commit, prefix string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name:    prefix + name,
+			ModTime: commit.Committer.When,
+			Format:  tar.FormatPAX,
+		}
+		switch entry.Mode {
+		case filemode.Dir:
+			header.Typeflag, header.Name, header.Mode = tar.TypeDir, header.Name+`/`, 0775
+			if err = archive.WriteHeader(header); err != nil {
+				return err
 			}
+			continue
+		case filemode.Submodule:
+			if err = writeGitSubmodule(archive, filepath.Join(path, name), entry.Hash, header); err != nil {
+				return err
+			}
+			continue
+		}
+		blob, err := r.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		content, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		if entry.Mode == filemode.Symlink {
+			target, err := io.ReadAll(content)
+			content.Close()
+			if err != nil {
+				return err
+			}
+			header.Typeflag, header.Linkname, header.Mode = tar.TypeSymlink, string(target), 0777
+			if err = archive.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+		header.Typeflag, header.Size, header.Mode = tar.TypeReg, blob.Size, 0664
+		if entry.Mode == filemode.Executable {
+			header.Mode = 0775
+		}
+		if err = archive.WriteHeader(header); err == nil {
+			_, err = io.Copy(archive, content)
+		}
+		content.Close()
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if len(result) == 0 {
-		return result, fmt.Errorf(`path <%s> does not appear to be a git folder`, path)
+// writeGitSubmodule adds the submodule tree at the recorded commit. Submodules that
+// were never checked out, or that lack the commit, are left as empty directories.
+func writeGitSubmodule(archive *tar.Writer, path string, hash plumbing.Hash, header *tar.Header) error {
+	header.Typeflag, header.Mode = tar.TypeDir, 0775
+	prefix := header.Name + `/`
+	header.Name = prefix
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	r, err := openGitRepository(path)
+	if err != nil {
+		slog.Warn(`Git submodule is not checked out, skipping it`, `path`, path)
+		return nil
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		slog.Warn(`Git submodule does not have the commit, skipping it`, `path`, path, `commit`, hash.String())
+		return nil
+	}
+	return writeGitTree(archive, r, path, commit, prefix)
+}
+
+// GitSubmodules lists paths of submodules that are checked out in the repository
+// working tree, including nested ones. Paths are relative to the repository.
+func GitSubmodules(path string) ([]string, error) {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := r.Worktree()
+	if errors.Is(err, git.ErrIsBareRepository) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	subs, err := wt.Submodules()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	for _, sub := range subs {
+		p := sub.Config().Path
+		if _, err = openGitRepository(filepath.Join(path, p)); err != nil {
+			continue // not checked out
+		}
+		result = append(result, p)
+		nested, err := GitSubmodules(filepath.Join(path, p))
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nested {
+			result = append(result, filepath.Join(p, n))
+		}
 	}
 	return result, nil
 }
 
-// GitArchiveReader adds a git archive as a stream.
-func GitArchiveReader(path string, branch string) (*exec.Cmd, io.Reader, *io.PipeWriter) {
+// GitWorktreeChanges lists paths that differ from the last commit: modified, staged,
+// deleted, and untracked files that are not ignored. Status codes are mapped to paths.
+func GitWorktreeChanges(path string) (git.Status, error) {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+	for file, s := range status {
+		if s.Staging == git.Unmodified && s.Worktree == git.Unmodified {
+			delete(status, file)
+		}
+	}
+	return status, nil
+}
+
+// GitWorktreeReader streams a tar archive of uncommitted work found in the repository
+// working tree. File contents are taken from disk as they are, the repository index
+// and stash are left untouched. A `.git-status` entry lists every change, including
+// deleted files, in the short format of `git status`.
+func GitWorktreeReader(path string) io.ReadCloser {
 	r, w := io.Pipe()
-	p := exec.Command("/bin/bash", "-c", fmt.Sprintf(gitArchiveBranch, branch))
-	p.Dir = path
-	// p.Stdin = r
-	p.Stdout = w
-	p.Stderr = os.Stderr
-	return p, r, w
+	go func() {
+		w.CloseWithError(writeGitWorktree(w, path))
+	}()
+	return r
+}
+
+func writeGitWorktree(w io.Writer, path string) error {
+	status, err := GitWorktreeChanges(path)
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(status))
+	for file := range status {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	summary := &strings.Builder{}
+	for _, file := range files {
+		fmt.Fprintf(summary, "%c%c %s\n", status[file].Staging, status[file].Worktree, file)
+	}
+	archive := tar.NewWriter(w)
+	err = archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     `.git-status`,
+		Size:     int64(summary.Len()),
+		Mode:     0664,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(archive, summary.String()); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = addGitWorktreeFile(archive, path, file); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func addGitWorktreeFile(archive *tar.Writer, root, file string) error {
+	p := filepath.Join(root, filepath.FromSlash(file))
+	info, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil // deleted files are only listed in the summary
+	} else if err != nil {
+		return err
+	}
+	link := ``
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	} else if !info.Mode().IsRegular() {
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = file
+	if err = archive.WriteHeader(header); err != nil || link != `` {
+		return err
+	}
+	in, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.CopyN(archive, in, header.Size)
+	return err
 }
@@ -1,14 +1,279 @@
 package archiver
 
 import (
-	// "reflect"
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
-    // "log"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
+// testGitRepository creates a repository with one commit on an awkwardly named branch.
+func testGitRepository(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-git-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, `README.md`), []byte(`sane`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wt.Add(`README.md`); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit(`initial`, &git.CommitOptions{
+		Author: &object.Signature{Name: `sane`, Email: `sane@localhost`, When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := `fix";touch${IFS}pwned;"`
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, branch
+}
+
 func TestGitBranches(t *testing.T) {
-    _, err := GitBranchList(`.`)
-    if err != nil {
-        t.Fatal(err)
-    }
+	dir, branch := testGitRepository(t)
+	l, err := GitBranchList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// go-git does not promise any order of references
+	sort.Strings(l)
+	if expected := []string{branch, `master`}; strings.Join(l, "\n") != strings.Join(expected, "\n") {
+		t.Errorf(`expected branches %q, got %q`, expected, l)
+	}
+	if _, err = GitBranchList(filepath.Join(dir, `.git`, `objects`)); !errors.Is(err, ErrNotGitRepository) {
+		t.Errorf(`expected %q error, got %v`, ErrNotGitRepository, err)
+	}
+}
+
+func TestGitReferencesToTrees(t *testing.T) {
+	dir, branch := testGitRepository(t)
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(`tree`), commit.TreeHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.CreateTag(`annotated-tree`, commit.TreeHash, &git.CreateTagOptions{Message: `tree`,
+		Tagger: &object.Signature{Name: `sane`, Email: `sane@localhost`, When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.CreateTag(`release`, commit.Hash, &git.CreateTagOptions{Message: `release`,
+		Tagger: &object.Signature{Name: `sane`, Email: `sane@localhost`, When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := GitReferences(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(l))
+	for _, ref := range l {
+		names = append(names, ref.Name)
+	}
+	sort.Strings(names)
+	if expected := []string{branch, `master`, `release`}; strings.Join(names, "\n") != strings.Join(expected, "\n") {
+		t.Errorf(`expected references %q, got %q`, expected, names)
+	}
+}
+
+func TestGitHeadBranchWithoutCommits(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-git-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err = git.PlainInit(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := GitHeadBranch(dir); err != nil || head != `` {
+		t.Errorf(`expected no default branch, got %q and %v`, head, err)
+	}
+	if l, err := GitReferences(dir); err != nil || len(l) != 0 {
+		t.Errorf(`expected no references, got %v and %v`, l, err)
+	}
+}
+
+func TestGitArchiveReader(t *testing.T) {
+	dir, branch := testGitRepository(t)
+	r := GitArchiveReader(dir, branch)
+	defer r.Close()
+	archive := tar.NewReader(r)
+	found := false
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		found = found || header.Name == `README.md`
+	}
+	if !found {
+		t.Error(`README.md is missing from the archive`)
+	}
+	if _, err := os.Stat(filepath.Join(dir, `pwned`)); err == nil {
+		t.Error(`branch name was executed as a command`)
+	}
+
+	r = GitArchiveReader(dir, `missing`)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error(`archiving a missing branch should fail`)
+	}
+}
+
+func TestGitBundle(t *testing.T) {
+	dir, branch := testGitRepository(t)
+	r := GitBundleReader(dir)
+	defer r.Close()
+	restored := filepath.Join(dir, `restored`)
+	if err := RestoreGitBundle(r, restored); err != nil {
+		t.Fatal(err)
+	}
+	l, err := GitBranchList(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// go-git does not promise any order of references
+	sort.Strings(l)
+	if expected := []string{branch, `master`}; strings.Join(l, "\n") != strings.Join(expected, "\n") {
+		t.Errorf(`expected branches %q, got %q`, expected, l)
+	}
+	if _, err = os.Stat(filepath.Join(restored, `README.md`)); err != nil {
+		t.Error(`HEAD was not checked out:`, err)
+	}
+}
+
+func TestGitWorktreeReader(t *testing.T) {
+	dir, _ := testGitRepository(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, `README.md`), []byte(`changed`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, `notes.txt`), []byte(`new`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := GitWorktreeReader(dir)
+	defer r.Close()
+	archive := tar.NewReader(r)
+	found := make(map[string]string)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found[header.Name] = string(content)
+	}
+	if found[`README.md`] != `changed` || found[`notes.txt`] != `new` {
+		t.Errorf(`uncommitted work is missing from the archive: %q`, found)
+	}
+	if found[`.git-status`] != " M README.md\n?? notes.txt\n" {
+		t.Errorf(`unexpected status summary %q`, found[`.git-status`])
+	}
+}
+
+func TestDetectGitRepository(t *testing.T) {
+	dir, _ := testGitRepository(t)
+	if kind, err := DetectGitRepository(dir); err != nil || kind != GitWorkingRepository {
+		t.Errorf(`expected a working repository, got %s: %v`, kind, err)
+	}
+
+	bare := filepath.Join(dir, `bare.git`)
+	if _, err := git.PlainInit(bare, true); err != nil {
+		t.Fatal(err)
+	}
+	if kind, err := DetectGitRepository(bare); err != nil || kind != GitBareRepository {
+		t.Errorf(`expected a bare repository, got %s: %v`, kind, err)
+	}
+
+	// lay out a linked worktree the way `git worktree add` does
+	linked, data := filepath.Join(dir, `linked`), filepath.Join(dir, `.git`, `worktrees`, `linked`)
+	for p, content := range map[string]string{
+		filepath.Join(linked, `.git`):      `gitdir: ` + data + "\n",
+		filepath.Join(data, `commondir`):   "../..\n",
+		filepath.Join(data, `gitdir`):      filepath.Join(linked, `.git`) + "\n",
+		filepath.Join(data, `HEAD`):        "ref: refs/heads/master\n",
+		filepath.Join(linked, `README.md`): `sane`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if kind, err := DetectGitRepository(linked); err != nil || kind != GitLinkedWorktree {
+		t.Errorf(`expected a linked worktree, got %s: %v`, kind, err)
+	}
+	if branch, err := GitHeadBranch(linked); err != nil || branch != `master` {
+		t.Errorf(`expected master branch to be checked out, got %q: %v`, branch, err)
+	}
+}
+
+func TestGitSelection(t *testing.T) {
+	now := time.Now()
+	refs := []GitReference{
+		{Name: `main`, Updated: now},
+		{Name: `release/1.0`, Updated: now.AddDate(0, 0, -40)},
+		{Name: `release/2.0`, Updated: now.AddDate(0, 0, -2)},
+		{Name: `v1.0`, Tag: true, Updated: now.AddDate(0, 0, -40)},
+	}
+	cases := []struct {
+		Selection GitSelection
+		Expected  []string
+	}{
+		{GitSelection{}, []string{`main`, `release/1.0`, `release/2.0`}},
+		{GitSelection{DefaultBranch: true}, []string{`main`}},
+		{GitSelection{Branches: []string{`release/*`}, Tags: true}, []string{`release/1.0`, `release/2.0`, `v1.0`}},
+		{GitSelection{DefaultBranch: true, Branches: []string{`release/*`}, ActiveDays: 7}, []string{`main`, `release/2.0`}},
+	}
+	for _, c := range cases {
+		selected, reasons := c.Selection.Select(refs, `main`, now)
+		names := make([]string, 0)
+		for _, ref := range selected {
+			names = append(names, ref.Name)
+		}
+		if len(reasons) != len(refs) {
+			t.Errorf(`%+v: every reference should be explained, got %q`, c.Selection, reasons)
+		}
+		if strings.Join(names, `,`) != strings.Join(c.Expected, `,`) {
+			t.Errorf(`%+v: expected %q, got %q`, c.Selection, c.Expected, names)
+		}
+	}
 }
@@ -0,0 +1,150 @@
+package archiver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+)
+
+// gitBundleSignature opens every bundle, see `git help bundle-format`.
+const gitBundleSignature = "# v2 git bundle\n"
+
+// ErrGitBundleCorrupted indicates that a stream does not follow the git bundle format.
+var ErrGitBundleCorrupted = errors.New(`git bundle is corrupted`)
+
+// GitBundleReader streams a bundle with every reference and its full history,
+// like `git bundle create - --all` does. Any error encountered while reading
+// the repository is returned by the reader.
+func GitBundleReader(path string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeGitBundle(w, path))
+	}()
+	return r
+}
+
+func writeGitBundle(w io.Writer, path string) error {
+	r, err := openGitRepository(path)
+	if err != nil {
+		return err
+	}
+	refs, err := r.References()
+	if err != nil {
+		return err
+	}
+	header := &strings.Builder{}
+	header.WriteString(gitBundleSignature)
+	tips := make([]plumbing.Hash, 0)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil // symbolic references are recorded as HEAD below
+		}
+		fmt.Fprintf(header, "%s %s\n", ref.Hash(), ref.Name())
+		tips = append(tips, ref.Hash())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(tips) == 0 {
+		return fmt.Errorf(`git repository <%s> has no references`, path)
+	}
+	if head, err := r.Head(); err == nil {
+		fmt.Fprintf(header, "%s %s\n", head.Hash(), plumbing.HEAD)
+	}
+	header.WriteString("\n")
+
+	objects, err := revlist.Objects(r.Storer, tips, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, header.String()); err != nil {
+		return err
+	}
+	_, err = packfile.NewEncoder(w, r.Storer, false).Encode(objects, 10)
+	return err
+}
+
+// RestoreGitBundle clones a bundle into a new working repository at path,
+// recreating all references it contains and checking out its HEAD.
+func RestoreGitBundle(in io.Reader, path string) error {
+	b := bufio.NewReader(in)
+	line, err := b.ReadString('\n')
+	if err != nil || line != gitBundleSignature {
+		return ErrGitBundleCorrupted
+	}
+	refs := make([]*plumbing.Reference, 0)
+	var head plumbing.Hash
+	for {
+		line, err = b.ReadString('\n')
+		if err != nil {
+			return ErrGitBundleCorrupted
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == `` {
+			break
+		} else if strings.HasPrefix(line, `-`) {
+			return fmt.Errorf(`git bundle requires other commits: %w`, ErrGitBundleCorrupted)
+		}
+		fields := strings.SplitN(line, ` `, 2)
+		if len(fields) != 2 || !plumbing.IsHash(fields[0]) {
+			return ErrGitBundleCorrupted
+		}
+		hash := plumbing.NewHash(fields[0])
+		if fields[1] == plumbing.HEAD.String() {
+			head = hash
+			continue
+		}
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(fields[1]), hash))
+	}
+
+	r, err := git.PlainInit(path, false)
+	if err != nil {
+		return err
+	}
+	if err = packfile.UpdateObjectStorage(r.Storer, b); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err = r.Storer.SetReference(ref); err != nil {
+			return err
+		}
+	}
+	return checkoutGitHead(r, refs, head)
+}
+
+// checkoutGitHead points HEAD to the branch that HEAD was on when the bundle was made.
+func checkoutGitHead(r *git.Repository, refs []*plumbing.Reference, head plumbing.Hash) error {
+	if head.IsZero() {
+		return nil // nothing to check out
+	}
+	var branch *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head {
+			if branch == nil || ref.Name() == plumbing.Master || ref.Name() == plumbing.Main {
+				branch = ref
+			}
+		}
+	}
+	if branch == nil {
+		// HEAD was detached
+		branch = plumbing.NewHashReference(plumbing.HEAD, head)
+	} else {
+		branch = plumbing.NewSymbolicReference(plumbing.HEAD, branch.Name())
+	}
+	if err := r.Storer.SetReference(branch); err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&git.ResetOptions{Commit: head, Mode: git.HardReset})
+}
@@ -0,0 +1,90 @@
+package archiver
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// GitReference is a branch or a tag along with the time of the commit it points to.
+type GitReference struct {
+	Name    string // Short name, such as master or v1.0.
+	Tag     bool
+	Commit  string // Hash of the commit, in hex.
+	Updated time.Time
+}
+
+func (r GitReference) String() string {
+	if r.Tag {
+		return `tag ` + r.Name
+	}
+	return `branch ` + r.Name
+}
+
+// GitSelection decides which branches and tags of a git repository are archived.
+// If neither the default branch nor branch patterns are requested, every branch is selected.
+type GitSelection struct {
+	DefaultBranch bool     // Select the branch HEAD points to.
+	Branches      []string // Select branches matching glob patterns, such as release/*.
+	Tags          bool     // Select all tags.
+	ActiveDays    uint     // Skip branches without commits within this many days.
+}
+
+// Validate checks that branch patterns are well-formed.
+func (s GitSelection) Validate() error {
+	for _, pattern := range s.Branches {
+		if _, err := path.Match(pattern, ``); err != nil {
+			return fmt.Errorf(`git branch pattern %q: %w`, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Select filters references and explains why each one was taken or skipped.
+// The head is the name of the branch checked out in the repository.
+func (s GitSelection) Select(refs []GitReference, head string, now time.Time) ([]GitReference, []string) {
+	selected := make([]GitReference, 0, len(refs))
+	reasons := make([]string, 0, len(refs))
+	decide := func(ref GitReference, take bool, reason string, args ...interface{}) {
+		if take {
+			selected = append(selected, ref)
+			reasons = append(reasons, fmt.Sprintf(`selected %s: `+reason, append([]interface{}{ref}, args...)...))
+		} else {
+			reasons = append(reasons, fmt.Sprintf(`skipped %s: `+reason, append([]interface{}{ref}, args...)...))
+		}
+	}
+	cutoff := now.AddDate(0, 0, -int(s.ActiveDays))
+	all := !s.DefaultBranch && len(s.Branches) == 0
+
+refs:
+	for _, ref := range refs {
+		if ref.Tag {
+			if s.Tags {
+				decide(ref, true, `tags are included`)
+			} else {
+				decide(ref, false, `tags are excluded`)
+			}
+			continue
+		}
+		if s.ActiveDays > 0 && ref.Updated.Before(cutoff) {
+			decide(ref, false, `last commit is older than %d days`, s.ActiveDays)
+			continue
+		}
+		switch {
+		case all:
+			decide(ref, true, `all branches are included`)
+			continue refs
+		case s.DefaultBranch && ref.Name == head:
+			decide(ref, true, `it is the default branch`)
+			continue refs
+		}
+		for _, pattern := range s.Branches {
+			if ok, _ := path.Match(pattern, ref.Name); ok {
+				decide(ref, true, `matches %q`, pattern)
+				continue refs
+			}
+		}
+		decide(ref, false, `no selection rule matches`)
+	}
+	return selected, reasons
+}
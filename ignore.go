@@ -0,0 +1,122 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// IgnoreFileName holds exclusion rules for a directory and its descendants, using .gitignore syntax.
+const IgnoreFileName = `.saneignore`
+
+// cacheDirTagSignature opens CACHEDIR.TAG files, see https://bford.info/cachedir/
+var cacheDirTagSignature = []byte(`Signature: 8a477f597d28d172789f06886806bc55`)
+
+// IgnoreRules decide which paths the directory walker skips. Patterns follow .gitignore
+// syntax and are relative to the walked target. Directories tagged with CACHEDIR.TAG
+// are always skipped, as are paths matching rules from .saneignore files.
+type IgnoreRules struct {
+	Exclude          []string // Skip paths matching these patterns.
+	Include          []string // Keep paths matching these patterns even if they were excluded.
+	RespectGitignore bool     // Also read .gitignore files found outside of git repositories.
+}
+
+// ignorePattern remembers where a pattern came from, so that skipped paths can be explained.
+type ignorePattern struct {
+	gitignore.Pattern
+	source string
+}
+
+// ignoreList accumulates patterns as the walker descends into directories.
+type ignoreList struct {
+	root     string
+	patterns []ignorePattern
+	includes []ignorePattern // Override every other pattern, wherever it was loaded from.
+}
+
+func newIgnoreList(root string, rules IgnoreRules) *ignoreList {
+	l := &ignoreList{root: root}
+	for _, p := range rules.Exclude {
+		l.patterns = append(l.patterns, ignorePattern{gitignore.ParsePattern(p, nil), fmt.Sprintf(`--exclude %q`, p)})
+	}
+	for _, p := range rules.Include {
+		l.includes = append(l.includes, ignorePattern{gitignore.ParsePattern(`!`+p, nil), fmt.Sprintf(`--include %q`, p)})
+	}
+	return l
+}
+
+// split turns a path into its components relative to the walked target.
+func (l *ignoreList) split(path string) []string {
+	rel, err := filepath.Rel(l.root, path)
+	if err != nil || rel == `.` {
+		return []string{}
+	}
+	return strings.Split(filepath.ToSlash(rel), `/`)
+}
+
+// load reads patterns from an ignore file in the directory, if there is one.
+func (l *ignoreList) load(dir string, name string) error {
+	file := filepath.Join(dir, name)
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	domain := l.split(dir)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == `` || strings.HasPrefix(line, `#`) {
+			continue
+		}
+		l.patterns = append(l.patterns, ignorePattern{
+			gitignore.ParsePattern(line, domain), fmt.Sprintf(`%q at %s:%d`, line, file, n)})
+	}
+	return scanner.Err()
+}
+
+// skip explains why the path must be skipped or returns an empty string if it must be kept.
+// The last matching pattern wins, so that rules deeper in the tree override those above,
+// but paths given with --include are kept whatever other patterns say.
+func (l *ignoreList) skip(path string, isDir bool) string {
+	parts := l.split(path)
+	if len(parts) > 0 {
+		for _, p := range l.includes {
+			if p.Match(parts, isDir) == gitignore.Include {
+				return ``
+			}
+		}
+		for i := len(l.patterns) - 1; i >= 0; i-- {
+			switch l.patterns[i].Match(parts, isDir) {
+			case gitignore.Exclude:
+				return `matches ` + l.patterns[i].source
+			case gitignore.Include:
+				return ``
+			}
+		}
+	}
+	if isDir && isCacheDir(path) {
+		return `it is tagged with CACHEDIR.TAG`
+	}
+	return ``
+}
+
+// isCacheDir returns true if the directory holds a valid CACHEDIR.TAG file.
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, `CACHEDIR.TAG`))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	signature := make([]byte, len(cacheDirTagSignature))
+	_, err = io.ReadFull(f, signature)
+	return err == nil && bytes.Equal(signature, cacheDirTagSignature)
+}
@@ -0,0 +1,104 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// ManifestName is the archive entry that describes how and from what the archive was made.
+const ManifestName = `.sane-archiver/manifest.json`
+
+// Manifest describes an archive. It is stored encrypted along with the contents,
+// so it can only be read by the holder of the private key.
+type Manifest struct {
+	Version  string           `json:"version"`
+	Host     string           `json:"host"`
+	Created  time.Time        `json:"created"`
+	Targets  []string         `json:"targets,omitempty"`
+	Sources  []string         `json:"sources,omitempty"` // Names of entries made from command output.
+	Files    []ManifestFile   `json:"files"`
+	Git      []ManifestGitRef `json:"git,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+
+	mu sync.Mutex
+}
+
+// ManifestFile is a regular file stored in the archive.
+type ManifestFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	SHA256   string    `json:"sha256"`
+}
+
+// ManifestGitRef is a branch or a tag of a git repository at the time it was archived.
+type ManifestGitRef struct {
+	Repository string `json:"repository"`
+	Reference  string `json:"reference"` // Such as "branch master" or "tag v1.0".
+	Commit     string `json:"commit"`
+}
+
+func (m *Manifest) addFile(f ManifestFile) {
+	m.mu.Lock()
+	m.Files = append(m.Files, f)
+	m.mu.Unlock()
+}
+
+func (m *Manifest) addGitRefs(repository string, refs []GitReference) {
+	m.mu.Lock()
+	for _, ref := range refs {
+		m.Git = append(m.Git, ManifestGitRef{Repository: repository, Reference: ref.String(), Commit: ref.Commit})
+	}
+	m.mu.Unlock()
+}
+
+// checksum tees contents written into dst through a SHA-256 hash, if a manifest is kept.
+func (w *SaneWriter) checksum(dst io.Writer) (io.Writer, hash.Hash) {
+	if w.Manifest == nil {
+		return dst, nil
+	}
+	h := sha256.New()
+	return io.MultiWriter(dst, h), h
+}
+
+// record adds a file to the manifest, if one is kept.
+func (w *SaneWriter) record(name string, size int64, modified time.Time, h hash.Hash) {
+	if w.Manifest == nil || h == nil {
+		return
+	}
+	w.Manifest.addFile(ManifestFile{Name: name, Size: size, Modified: modified, SHA256: hex.EncodeToString(h.Sum(nil))})
+}
+
+// writeManifest stores the manifest as the last entry of the archive.
+func (w *SaneWriter) writeManifest() error {
+	// files compressed in the background are recorded before the manifest is taken
+	if err := w.stopPipeline(); err != nil {
+		return err
+	}
+	m, p := w.Manifest, w.Progress
+	w.Manifest, w.Progress = nil, nil // the manifest neither lists nor counts itself
+	defer func() { w.Manifest, w.Progress = m, p }()
+	m.mu.Lock()
+	contents, err := json.MarshalIndent(m, ``, `  `)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var r io.Reader = bytes.NewReader(contents)
+	return w.AddReader(ManifestName, &r)
+}
+
+// ReadManifest decodes a manifest entry.
+func ReadManifest(in io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(in).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,63 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir, _ := testTree(t, 10, 20000)
+	defer os.RemoveAll(dir)
+
+	for _, jobs := range []int{1, 4} {
+		var b bytes.Buffer
+		w := &SaneWriter{PublicKey: testPublicKey, Writer: &b, Jobs: jobs, Manifest: &Manifest{Host: `test`}}
+		if err := (&SaneDirectoryWalker{Target: dir}).Walk(w); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, size, err := NewSaneReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()), testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := zip.NewReader(r, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := z.File[len(z.File)-1]
+		if last.Name != ManifestName {
+			t.Fatalf(`manifest must be the last entry, found %s`, last.Name)
+		}
+		in, err := last.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := ReadManifest(in)
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Host != `test` || len(m.Files) != 10 {
+			t.Fatalf(`manifest of %d jobs describes %d files from <%s>`, jobs, len(m.Files), m.Host)
+		}
+		for _, f := range m.Files {
+			contents, err := ioutil.ReadFile(filepath.Join(dir, path.Base(f.Name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(contents)
+			if f.SHA256 != hex.EncodeToString(sum[:]) || f.Size != int64(len(contents)) {
+				t.Fatalf(`manifest of %d jobs does not match file %s`, jobs, f.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !linux && !darwin
+
+package archiver
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+)
+
+var errMetadataUnsupported = errors.New(`not supported on this platform`)
+
+type fileIdentity struct{}
+
+func hardLinkIdentity(info os.FileInfo) (fileIdentity, bool) {
+	return fileIdentity{}, false
+}
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattr(path string, name string, value string) error {
+	return errMetadataUnsupported
+}
+
+func makeNode(path string, header *tar.Header) error {
+	return errMetadataUnsupported
+}
+
+func setSymlinkTime(path string, header *tar.Header) error {
+	return nil
+}
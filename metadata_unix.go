@@ -0,0 +1,82 @@
+//go:build linux || darwin
+
+package archiver
+
+import (
+	"archive/tar"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileIdentity tells whether two paths are hard links to the same file.
+type fileIdentity struct {
+	device, inode uint64
+}
+
+// hardLinkIdentity returns the identity of a file that has more than one name.
+func hardLinkIdentity(info os.FileInfo) (fileIdentity, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || info.IsDir() || st.Nlink < 2 {
+		return fileIdentity{}, false
+	}
+	return fileIdentity{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// readXattrs collects extended attributes of a file without following symbolic links.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Llistxattr(path, list); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string]string)
+	for start, i := 0, 0; i < size; i++ {
+		if list[i] != 0 {
+			continue
+		}
+		name := string(list[start:i])
+		start = i + 1
+		n, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(path, name, value); err != nil {
+			return nil, err
+		}
+		attrs[name] = string(value[:n])
+	}
+	return attrs, nil
+}
+
+// writeXattr sets an extended attribute without following symbolic links.
+func writeXattr(path string, name string, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
+
+// makeNode creates a device node or a named pipe described by the header.
+func makeNode(path string, header *tar.Header) error {
+	mode := uint32(header.Mode & 07777)
+	switch header.Typeflag {
+	case tar.TypeFifo:
+		return unix.Mkfifo(path, mode)
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	}
+	return unix.Mknod(path, mode, int(unix.Mkdev(uint32(header.Devmajor), uint32(header.Devminor))))
+}
+
+// setSymlinkTime changes modification time of a symbolic link itself.
+func setSymlinkTime(path string, header *tar.Header) error {
+	t := unix.NsecToTimeval(header.ModTime.UnixNano())
+	return unix.Lutimes(path, []unix.Timeval{t, t})
+}
@@ -0,0 +1,278 @@
+package archiver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+)
+
+// PaddingPolicy decides how much filler is added to archives, so that their size
+// tells less about their contents.
+type PaddingPolicy uint8
+
+const (
+	// PadNone adds nothing, archives keep the original envelope readable by older versions.
+	PadNone PaddingPolicy = iota
+	// PadPowerOfTwo grows archives to the next power of two, wasting up to half of the space.
+	PadPowerOfTwo
+	// PadPadme follows the PADMÉ scheme, which leaks only O(log log n) bits of the size
+	// while wasting at most 12% of the space.
+	PadPadme
+	// PadRandom adds a random amount of filler up to the Range.
+	PadRandom
+)
+
+// PaddingPolicies maps policy names, as used on the command line, to policies.
+var PaddingPolicies = map[string]PaddingPolicy{
+	`none`:   PadNone,
+	`pow2`:   PadPowerOfTwo,
+	`padme`:  PadPadme,
+	`random`: PadRandom,
+}
+
+// Padding is applied inside the encrypted envelope and is stripped when the archive is read.
+type Padding struct {
+	Policy PaddingPolicy
+	Range  uint64 // Largest amount of random filler in bytes, for PadRandom.
+}
+
+// Validate checks that random padding has a range to pick from.
+func (p Padding) Validate() error {
+	if p.Policy == PadRandom && p.Range == 0 {
+		return errors.New(`random padding needs a range greater than zero`)
+	}
+	return nil
+}
+
+// filler returns how many bytes must be added to a stream of the given length.
+func (p Padding) filler(length uint64) (uint64, error) {
+	switch p.Policy {
+	case PadPowerOfTwo:
+		if length&(length-1) == 0 {
+			return 0, nil
+		}
+		return 1<<bits.Len64(length) - length, nil
+	case PadPadme:
+		if length < 2 {
+			return 0, nil
+		}
+		e := uint64(bits.Len64(length) - 1) // floor(log2(length))
+		s := uint64(bits.Len64(e))          // floor(log2(e)) + 1
+		mask := uint64(1)<<(e-s) - 1        // low bits that are rounded up
+		return (length+mask)&^mask - length, nil
+	case PadRandom:
+		n, err := rand.Int(rand.Reader, new(big.Int).SetUint64(p.Range+1))
+		if err != nil {
+			return 0, err
+		}
+		return n.Uint64(), nil
+	}
+	return 0, nil
+}
+
+const (
+	// envelopeFramed marks envelopes that carry length-prefixed frames followed by padding.
+	// Envelopes without a version hold the raw stream.
+	envelopeFramed = 2
+	// frameSize is the largest amount of contents in one frame.
+	frameSize = 64 * 1024
+	// frameHeaderSize holds the length of a frame, a zero length ends the contents.
+	frameHeaderSize = 4
+	// trailerSize holds the length of the contents at the very end, for random access.
+	trailerSize = 8
+)
+
+// paddedWriter frames contents, so that padding can be told apart from them, and pads the stream on Close.
+type paddedWriter struct {
+	out     *cipher.StreamWriter
+	padding Padding
+	frame   []byte
+	written uint64 // all bytes including the envelope header
+	length  uint64 // contents only
+}
+
+// NewPaddedSealWriter works as NewSealWriter, but pads the stream on Close according to
+// the policy. The envelope is only readable by versions that support padding.
+func NewPaddedSealWriter(out io.Writer, base64PublicKey string, padding Padding) (io.WriteCloser, error) {
+	if len(base64PublicKey) == 0 {
+		return nil, fmt.Errorf(`cannot perform operations using an empty key`)
+	}
+	if err := padding.Validate(); err != nil {
+		return nil, err
+	}
+	key := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(nonce); err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(Encrypt(base64PublicKey, append(key, envelopeFramed))); err != nil {
+		return nil, err
+	}
+	return &paddedWriter{
+		out: &cipher.StreamWriter{
+			S: cipher.NewCTR(SetupSymmetricCipherBlock(key), nonce), W: nopWriteCloser{out}},
+		padding: padding,
+		frame:   make([]byte, 0, frameSize),
+		written: HeaderSize,
+	}, nil
+}
+
+func (w *paddedWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		taken := copy(w.frame[len(w.frame):frameSize], p)
+		w.frame = w.frame[:len(w.frame)+taken]
+		p, n = p[taken:], n+taken
+		if len(w.frame) == frameSize {
+			if err = w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *paddedWriter) write(p []byte) error {
+	n, err := w.out.Write(p)
+	w.written += uint64(n)
+	return err
+}
+
+// flush writes out the buffered frame, an empty frame ends the contents.
+func (w *paddedWriter) flush() error {
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(w.frame)))
+	if err := w.write(header); err != nil {
+		return err
+	}
+	if err := w.write(w.frame); err != nil {
+		return err
+	}
+	w.length += uint64(len(w.frame))
+	w.frame = w.frame[:0]
+	return nil
+}
+
+// Close ends the contents, adds the padding and the trailer. It does not close the output.
+func (w *paddedWriter) Close() error {
+	if len(w.frame) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(); err != nil { // terminating frame
+		return err
+	}
+	filler, err := w.padding.filler(w.written + trailerSize)
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, frameSize) // encrypted, so they look random
+	for filler > 0 {
+		n := uint64(len(zeros))
+		if filler < n {
+			n = filler
+		}
+		if err = w.write(zeros[:n]); err != nil {
+			return err
+		}
+		filler -= n
+	}
+	trailer := make([]byte, trailerSize)
+	binary.BigEndian.PutUint64(trailer, w.length)
+	return w.write(trailer)
+}
+
+// ErrCorruptedFrame means that framed contents of a padded archive could not be read.
+var ErrCorruptedFrame = errors.New(`archive frame is corrupted, the key may be wrong`)
+
+// frameReader strips frame headers and everything after the terminating frame.
+type frameReader struct {
+	in   io.Reader
+	left uint32 // unread contents of the current frame
+	done bool
+}
+
+func (r *frameReader) Read(p []byte) (n int, err error) {
+	for r.left == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		header := make([]byte, frameHeaderSize)
+		if _, err = io.ReadFull(r.in, header); err != nil {
+			return 0, ErrCorruptedFrame
+		}
+		r.left = binary.BigEndian.Uint32(header)
+		if r.left > frameSize {
+			return 0, ErrCorruptedFrame
+		}
+		r.done = r.left == 0
+	}
+	if uint32(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err = r.in.Read(p)
+	r.left -= uint32(n)
+	if err == io.EOF {
+		err = ErrCorruptedFrame
+	}
+	return n, err
+}
+
+// frameReaderAt maps offsets of contents onto frames, which are all full except the last one.
+type frameReaderAt struct {
+	in     io.ReaderAt
+	length int64
+}
+
+func newFrameReaderAt(in io.ReaderAt, size int64) (*frameReaderAt, error) {
+	if size < trailerSize {
+		return nil, ErrCorruptedFrame
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := in.ReadAt(trailer, size-trailerSize); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint64(trailer))
+	frames := (length + frameSize - 1) / frameSize
+	if length < 0 || length+(frames+1)*frameHeaderSize+trailerSize > size {
+		return nil, ErrCorruptedFrame
+	}
+	return &frameReaderAt{in: in, length: length}, nil
+}
+
+func (r *frameReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= r.length {
+		return 0, io.EOF
+	}
+	for len(p) > 0 && off < r.length {
+		frame, inside := off/frameSize, off%frameSize
+		chunk := p
+		if left := frameSize - inside; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		if left := r.length - off; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		m, err := r.in.ReadAt(chunk, frame*(frameSize+frameHeaderSize)+frameHeaderSize+inside)
+		n, off, p = n+m, off+int64(m), p[m:]
+		if err != nil {
+			return n, err
+		}
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,88 @@
+package archiver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestPaddingFiller(t *testing.T) {
+	for _, c := range []struct {
+		policy PaddingPolicy
+		length uint64
+		want   uint64
+	}{
+		{PadPowerOfTwo, 1000, 24},
+		{PadPowerOfTwo, 1024, 0},
+		{PadPadme, 1000, 24},    // E=9, S=4, rounded up to a multiple of 32
+		{PadPadme, 1 << 20, 0},  // powers of two are kept
+		{PadPadme, 100001, 351}, // E=16, S=5, rounded up to a multiple of 2048
+		{PadNone, 1000, 0},
+	} {
+		got, err := Padding{Policy: c.policy}.filler(c.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf(`policy %d for %d bytes: expected %d bytes of filler, got %d`, c.policy, c.length, c.want, got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got, _ := (Padding{Policy: PadRandom, Range: 10}).filler(5); got > 10 {
+			t.Fatalf(`random filler %d is out of range`, got)
+		}
+	}
+}
+
+func TestPaddedEnvelope(t *testing.T) {
+	contents := make([]byte, 3*frameSize+123)
+	rand.New(rand.NewSource(1)).Read(contents)
+	for name, padding := range map[string]Padding{
+		`pow2`:   {Policy: PadPowerOfTwo},
+		`padme`:  {Policy: PadPadme},
+		`random`: {Policy: PadRandom, Range: 4096},
+	} {
+		var b bytes.Buffer
+		w, err := NewPaddedSealWriter(&b, testPublicKey, padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if extra, _ := padding.filler(uint64(b.Len())); padding.Policy != PadRandom && extra != 0 {
+			t.Errorf(`%s: size %d does not follow the policy`, name, b.Len())
+		}
+
+		r, err := NewSaneReader(bytes.NewReader(b.Bytes()), testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(streamed, contents) {
+			t.Errorf(`%s: streamed contents do not match`, name)
+		}
+
+		ra, size, err := NewSaneReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()), testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(contents)) {
+			t.Fatalf(`%s: expected %d bytes of contents, got %d`, name, len(contents), size)
+		}
+		for _, off := range []int64{0, frameSize - 3, frameSize, 2*frameSize + 1, size - 10} {
+			p := make([]byte, 20)
+			n, _ := ra.ReadAt(p, off)
+			if want := contents[off:]; !bytes.Equal(p[:n], want[:min(len(want), len(p))]) {
+				t.Errorf(`%s: contents at %d do not match`, name, off)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package archiver
+
+import (
+	"archive/zip"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// memoryBufferLimit is the largest file compressed in memory, larger ones are spooled to disk.
+const memoryBufferLimit = 4 * 1024 * 1024
+
+// pendingEntry is a file compressed in the background, waiting for its turn in the archive.
+type pendingEntry struct {
+	path   string
+	header *zip.FileHeader
+	body   entryBuffer
+	sha256 hash.Hash // of uncompressed contents, if a manifest is kept
+	done   chan error
+}
+
+// pipeline compresses several files at once, while a single goroutine writes
+// finished entries into the archive in the order they were added. At most Jobs
+// files are compressed and as many wait in the queue, which bounds memory use.
+type pipeline struct {
+	queue   chan *pendingEntry
+	workers chan struct{}
+	drained chan struct{}
+
+	mu     sync.Mutex
+	err    error // failure to write into the archive, which ruins it
+	failed []SkippedPath
+}
+
+func (w *SaneWriter) startPipeline() {
+	w.pipeline = &pipeline{
+		queue:   make(chan *pendingEntry, w.Jobs),
+		workers: make(chan struct{}, w.Jobs),
+		drained: make(chan struct{}),
+	}
+	go w.drain(w.pipeline)
+}
+
+// enqueue compresses the file in the background and takes ownership of it.
+func (w *SaneWriter) enqueue(target string, header *zip.FileHeader, in *os.File) error {
+	if w.pipeline == nil {
+		w.startPipeline()
+	}
+	p := w.pipeline
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		in.Close()
+		return err
+	}
+	e := &pendingEntry{path: target, header: header, done: make(chan error, 1)}
+	p.workers <- struct{}{}
+	go func() {
+		e.done <- w.compress(e, in)
+		in.Close()
+		<-p.workers
+	}()
+	p.queue <- e
+	return nil
+}
+
+// compress fills the entry buffer with compressed contents and records their checksum and sizes.
+func (w *SaneWriter) compress(e *pendingEntry, in io.Reader) (err error) {
+	if e.header.UncompressedSize64 > memoryBufferLimit {
+		if e.body, err = newSpool(); err != nil {
+			return err
+		}
+	} else {
+		e.body = &memoryBuffer{}
+	}
+	r := w.chooseMethod(e.header, w.Progress.reader(in))
+	var out io.WriteCloser = nopWriteCloser{e.body}
+	if e.header.Method != zip.Store {
+		if out, err = zipCompressor(w.Compression, w.CompressionLevel)(e.body); err != nil {
+			return err
+		}
+	}
+	checksum := crc32.NewIEEE()
+	dst, h := w.checksum(io.MultiWriter(out, checksum))
+	e.sha256 = h
+	n, err := io.Copy(dst, r)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	e.header.CRC32 = checksum.Sum32()
+	e.header.UncompressedSize64 = uint64(n)
+	e.header.CompressedSize64 = uint64(e.body.Len())
+	return nil
+}
+
+// drain writes compressed entries into the archive in order until the queue is closed.
+func (w *SaneWriter) drain(p *pipeline) {
+	defer close(p.drained)
+	for e := range p.queue {
+		err := <-e.done
+		p.mu.Lock()
+		ruined := p.err != nil
+		if err != nil {
+			slog.Warn(`File could not be compressed`, `path`, e.path, `error`, err)
+			p.failed = append(p.failed, SkippedPath{Path: e.path, Err: err})
+		}
+		p.mu.Unlock()
+		if err == nil && !ruined {
+			if err = w.writeCompressed(e); err != nil {
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+			}
+		}
+		if e.body != nil {
+			e.body.Close()
+		}
+	}
+}
+
+func (w *SaneWriter) writeCompressed(e *pendingEntry) error {
+	f, err := w.archiveHandle.CreateRaw(e.header)
+	if err != nil {
+		return err
+	}
+	body, err := e.body.Reader()
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, body); err != nil {
+		return err
+	}
+	w.Size += e.header.UncompressedSize64
+	w.record(e.header.Name, int64(e.header.UncompressedSize64), e.header.Modified, e.sha256)
+	w.added(e.path, int64(e.header.UncompressedSize64))
+	return nil
+}
+
+// stopPipeline waits until queued entries are written and keeps files that failed for Flush.
+func (w *SaneWriter) stopPipeline() error {
+	p := w.pipeline
+	if p == nil {
+		return nil
+	}
+	close(p.queue)
+	<-p.drained
+	w.pipeline = nil
+	w.failed = append(w.failed, p.failed...)
+	return p.err
+}
+
+// Flush waits until every file added so far is written into the archive. It returns files
+// that could not be read while being compressed in the background, which were left out.
+// The error is only set when the archive itself could not be written and is ruined.
+// Files are only compressed in the background when Jobs is more than one.
+func (w *SaneWriter) Flush() ([]SkippedPath, error) {
+	err := w.stopPipeline()
+	failed := w.failed
+	w.failed = nil
+	return failed, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// flushBeforeClose makes sure that files compressed in the background are not lost silently.
+func (w *SaneWriter) flushBeforeClose() error {
+	failed, err := w.Flush()
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf(`%d file(s) could not be archived, first one is %s`, len(failed), failed[0])
+	}
+	return nil
+}
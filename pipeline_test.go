@@ -0,0 +1,107 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTree creates files of compressible text, with one large enough to be spooled.
+func testTree(t testing.TB, files int, size int) (string, int64) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-pipeline-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := rand.New(rand.NewSource(1))
+	words := []string{`sane`, `archiver`, `keeps`, `files`, `safe`, `and`, `sound`}
+	total := int64(0)
+	for i := 0; i < files; i++ {
+		n := size
+		if i == files/2 {
+			n = memoryBufferLimit + 1
+		}
+		b := &strings.Builder{}
+		for b.Len() < n {
+			b.WriteString(words[random.Intn(len(words))] + ` `)
+		}
+		p := filepath.Join(dir, fmt.Sprintf(`%03d.txt`, i))
+		if err = ioutil.WriteFile(p, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		total += int64(b.Len())
+	}
+	return dir, total
+}
+
+func testPack(t testing.TB, dir string, jobs int) []byte {
+	var b bytes.Buffer
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: &b, Jobs: jobs}
+	if err := (&SaneDirectoryWalker{Target: dir}).Walk(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParallelOrdering(t *testing.T) {
+	dir, _ := testTree(t, 40, 20000)
+	defer os.RemoveAll(dir)
+
+	contents := func(archive []byte) []string {
+		r, size, err := NewSaneReaderAt(bytes.NewReader(archive), int64(len(archive)), testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := zip.NewReader(r, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := make([]string, 0, len(z.File))
+		for _, f := range z.File {
+			in, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(in)
+			in.Close()
+			if err != nil {
+				t.Fatalf(`%s: %s`, f.Name, err)
+			}
+			l = append(l, f.Name+`:`+string(content))
+		}
+		return l
+	}
+	serial, parallel := contents(testPack(t, dir, 1)), contents(testPack(t, dir, 8))
+	if len(serial) != 40 || len(parallel) != len(serial) {
+		t.Fatalf(`expected 40 files, got %d and %d`, len(serial), len(parallel))
+	}
+	for i := range serial {
+		if serial[i] != parallel[i] {
+			t.Fatalf(`entry %d differs: %.40q and %.40q`, i, serial[i], parallel[i])
+		}
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	dir, total := testTree(b, 64, 256*1024)
+	defer os.RemoveAll(dir)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, jobs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf(`jobs=%d`, jobs), func(b *testing.B) {
+			b.SetBytes(total)
+			for i := 0; i < b.N; i++ {
+				testPack(b, dir, jobs)
+			}
+		})
+	}
+}
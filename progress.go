@@ -0,0 +1,148 @@
+package archiver
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Stage is the kind of work reported by Progress.
+type Stage int32
+
+const (
+	// StageScanning counts files that are about to be packed.
+	StageScanning Stage = iota
+	// StagePacking adds files into the archive.
+	StagePacking
+	// StageUploading sends the finished archive to its destinations.
+	StageUploading
+	// StageDone means that nothing is left to do.
+	StageDone
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageScanning:
+		return `scanning`
+	case StagePacking:
+		return `packing`
+	case StageUploading:
+		return `uploading`
+	}
+	return `done`
+}
+
+// Progress counts work done while an archive is made. The walker, the writer and uploads
+// update it from their own goroutines, while another one may read it with Snapshot.
+// Methods of a nil Progress do nothing.
+type Progress struct {
+	stage       int32
+	files       int64
+	bytes       int64
+	totalFiles  int64
+	totalBytes  int64
+	uploaded    int64
+	uploadTotal int64
+}
+
+// ProgressSnapshot is the state of Progress at one moment. Totals are zero while unknown.
+type ProgressSnapshot struct {
+	Stage       Stage
+	Files       int64 // Files added to the archive.
+	TotalFiles  int64
+	Bytes       int64 // Bytes read from added files, before compression.
+	TotalBytes  int64
+	Uploaded    int64 // Bytes sent to all destinations together.
+	UploadTotal int64
+}
+
+// Snapshot reads every counter.
+func (p *Progress) Snapshot() ProgressSnapshot {
+	if p == nil {
+		return ProgressSnapshot{Stage: StageDone}
+	}
+	return ProgressSnapshot{
+		Stage:       Stage(atomic.LoadInt32(&p.stage)),
+		Files:       atomic.LoadInt64(&p.files),
+		TotalFiles:  atomic.LoadInt64(&p.totalFiles),
+		Bytes:       atomic.LoadInt64(&p.bytes),
+		TotalBytes:  atomic.LoadInt64(&p.totalBytes),
+		Uploaded:    atomic.LoadInt64(&p.uploaded),
+		UploadTotal: atomic.LoadInt64(&p.uploadTotal),
+	}
+}
+
+// SetStage moves on to the next kind of work.
+func (p *Progress) SetStage(s Stage) {
+	if p != nil {
+		atomic.StoreInt32(&p.stage, int32(s))
+	}
+}
+
+// AddTotal accounts for files found by SaneDirectoryWalker.Count or otherwise known in advance.
+func (p *Progress) AddTotal(files int64, bytes int64) {
+	if p != nil {
+		atomic.AddInt64(&p.totalFiles, files)
+		atomic.AddInt64(&p.totalBytes, bytes)
+	}
+}
+
+// AddUploadTotal accounts for bytes that are about to be uploaded.
+func (p *Progress) AddUploadTotal(bytes int64) {
+	if p != nil {
+		atomic.AddInt64(&p.uploadTotal, bytes)
+	}
+}
+
+func (p *Progress) addFile() {
+	if p != nil {
+		atomic.AddInt64(&p.files, 1)
+	}
+}
+
+// reader counts bytes of contents as they are read for the archive.
+func (p *Progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{Reader: r, counter: &p.bytes}
+}
+
+type progressReader struct {
+	io.Reader
+	counter *int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	atomic.AddInt64(r.counter, int64(n))
+	return n, err
+}
+
+// countSent is a request option that counts bytes of request bodies as they are sent.
+// The SDK reads bodies once more to sign them and again for every retry, so only reads
+// made while sending count, and bytes of a failed attempt are taken back before the next.
+func (p *Progress) countSent(r *request.Request) {
+	var sent int64
+	r.Handlers.Send.PushFront(func(r *request.Request) {
+		atomic.AddInt64(&p.uploaded, -atomic.SwapInt64(&sent, 0))
+		if r.HTTPRequest.Body != nil && r.HTTPRequest.Body != http.NoBody {
+			r.HTTPRequest.Body = &sentBody{ReadCloser: r.HTTPRequest.Body, uploaded: &p.uploaded, sent: &sent}
+		}
+	})
+}
+
+type sentBody struct {
+	io.ReadCloser
+	uploaded *int64
+	sent     *int64
+}
+
+func (b *sentBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.uploaded, int64(n))
+	atomic.AddInt64(b.sent, int64(n))
+	return n, err
+}
@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestProgress(t *testing.T) {
+	dir, total := testTree(t, 10, 20000)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, `skip.log`), []byte(`ignored`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ignore := IgnoreRules{Exclude: []string{`*.log`}}
+
+	files, size, err := (&SaneDirectoryWalker{Target: dir, Ignore: ignore}).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 10 || size != total {
+		t.Fatalf(`expected 10 files of %d bytes, counted %d files of %d bytes`, total, files, size)
+	}
+
+	for _, format := range []Format{FormatZip, FormatTar} {
+		for _, jobs := range []int{1, 4} {
+			p := &Progress{}
+			p.AddTotal(files, size)
+			p.SetStage(StagePacking)
+			w := &SaneWriter{PublicKey: testPublicKey, Writer: ioutil.Discard, Format: format,
+				Jobs: jobs, Progress: p, Manifest: &Manifest{}}
+			if err = (&SaneDirectoryWalker{Target: dir, Ignore: ignore}).Walk(w); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s := p.Snapshot()
+			if s.Stage != StagePacking || s.Files != s.TotalFiles || s.Bytes != s.TotalBytes {
+				t.Fatalf(`progress of %d jobs in format %d does not add up: %+v`, jobs, format, s)
+			}
+		}
+	}
+}
+
+func TestProgressCountsSentBytes(t *testing.T) {
+	p := &Progress{}
+	body := strings.Repeat(`x`, 1000)
+	r := &request.Request{HTTPRequest: &http.Request{Body: ioutil.NopCloser(strings.NewReader(body))}}
+	p.countSent(r)
+	// signing reads the body before it is sent
+	if _, err := io.Copy(ioutil.Discard, r.HTTPRequest.Body); err != nil {
+		t.Fatal(err)
+	}
+	// the first attempt fails halfway through and the body is sent again
+	for _, size := range []int64{400, 1000} {
+		r.HTTPRequest.Body = ioutil.NopCloser(strings.NewReader(body))
+		r.Handlers.Send.Run(r)
+		if _, err := io.CopyN(ioutil.Discard, r.HTTPRequest.Body, size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := p.Snapshot(); s.Uploaded != 1000 {
+		t.Errorf(`expected 1000 bytes sent, counted %d`, s.Uploaded)
+	}
+}
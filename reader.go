@@ -3,42 +3,153 @@ package archiver
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 )
 
-// Decode decrypts stored file.
+// HeaderSize is the length of the nonce and the encrypted key preceding the cipher stream.
+const HeaderSize = aes.BlockSize + KeyBytes
+
+// readHeader recovers the nonce and the symmetric cipher from the archive header,
+// and tells whether the contents are framed and padded.
+func readHeader(in io.Reader, base64PrivateKey string) (cipher.Block, []byte, bool, error) {
+	nonce := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(in, nonce); err != nil {
+		return nil, nil, false, err
+	}
+	secret := make([]byte, KeyBytes)
+	if _, err := io.ReadFull(in, secret); err != nil {
+		return nil, nil, false, err
+	}
+	key := Decrypt(base64PrivateKey, secret)
+	switch {
+	case len(key) == aes.BlockSize:
+		return SetupSymmetricCipherBlock(key), nonce, false, nil
+	case len(key) == aes.BlockSize+1 && key[aes.BlockSize] == envelopeFramed:
+		return SetupSymmetricCipherBlock(key[:aes.BlockSize]), nonce, true, nil
+	}
+	return nil, nil, false, fmt.Errorf(`archive envelope version is not supported, a newer version of the archiver may be needed`)
+}
+
+// NewSaneReader returns a stream of decrypted archive contents.
+func NewSaneReader(in io.Reader, base64PrivateKey string) (io.Reader, error) {
+	block, nonce, framed, err := readHeader(in, base64PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	// TODO: cipher.NewOFB was used before, but that may cause problems with bit-rot.
+	r := &cipher.StreamReader{S: cipher.NewCTR(block, nonce), R: in}
+	if framed {
+		return &frameReader{in: r}, nil
+	}
+	return r, nil
+}
+
+// NewSaneReaderAt returns decrypted archive contents that can be read at any offset
+// without decrypting what comes before it. Size of the contents is also returned.
+func NewSaneReaderAt(in io.ReaderAt, size int64, base64PrivateKey string) (io.ReaderAt, int64, error) {
+	block, nonce, framed, err := readHeader(io.NewSectionReader(in, 0, HeaderSize), base64PrivateKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	r := &saneReaderAt{r: in, block: block, nonce: nonce}
+	if framed {
+		f, err := newFrameReaderAt(r, size-HeaderSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, f.length, nil
+	}
+	return r, size - HeaderSize, nil
+}
+
+// saneReaderAt seeks the CTR key stream to the requested offset.
+type saneReaderAt struct {
+	r     io.ReaderAt
+	block cipher.Block
+	nonce []byte
+}
+
+func (s *saneReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = s.r.ReadAt(p, HeaderSize+off)
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, s.nonce)
+	carry := uint64(off / aes.BlockSize)
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		carry += uint64(counter[i])
+		counter[i] = byte(carry)
+		carry >>= 8
+	}
+	stream := cipher.NewCTR(s.block, counter)
+	skip := make([]byte, off%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// openDecrypted opens the archive at target, a local path or a remote URL, for decryption.
+func openDecrypted(target string, base64PrivateKey string) (File, io.Reader, error) {
+	in, err := Open(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := NewSaneReader(in, base64PrivateKey)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, r, nil
+}
+
+// Decode decrypts stored file. Target can be a local path or a remote URL.
 func Decode(output string, target string, base64PrivateKey string) error {
-	in, err := os.OpenFile(target, os.O_RDONLY, 0755)
+	in, r, err := openDecrypted(target, base64PrivateKey)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
 
-	nonce := make([]byte, aes.BlockSize)
-	_, err = in.Read(nonce)
+	out, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	key := make([]byte, KeyBytes)
-	_, err = in.Read(key)
+	defer out.Close()
+	_, err = io.Copy(out, r)
 	if err != nil {
 		return err
 	}
-	cipherHandle := &cipher.StreamReader{
-		// TODO: cipher.NewOFB was used before, but that may cause problems with bit-rot.
-		S: cipher.NewCTR(SetupSymmetricCipherBlock(Decrypt(base64PrivateKey, key)), nonce), R: in}
+	slog.Info(`Archive successfully recovered`, `path`, out.Name())
+	return nil
+}
 
-	out, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE, 0600)
+// DecodeTo decrypts stored file into the writer, such as standard output.
+func DecodeTo(out io.Writer, target string, base64PrivateKey string) error {
+	in, r, err := openDecrypted(target, base64PrivateKey)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-	_, err = io.Copy(out, cipherHandle)
+	defer in.Close()
+	_, err = io.Copy(out, r)
+	return err
+}
+
+// DetectArchiveFormat decrypts the first bytes of the archive to tell its format
+// and, for tar streams, their compression.
+func DetectArchiveFormat(target string, base64PrivateKey string) (Format, Compression, error) {
+	in, err := Open(target)
 	if err != nil {
-		return err
+		return FormatZip, CompressionDeflate, err
 	}
-	log.Printf("Archive successfully recovered and stored as %s.", out.Name())
-	return nil
+	defer in.Close()
+	r, size, err := NewSaneReaderAt(in, in.Size(), base64PrivateKey)
+	if err != nil {
+		return FormatZip, CompressionDeflate, err
+	}
+	magic := make([]byte, len(zstdMagic))
+	if _, err = r.ReadAt(magic, 0); err != nil && size >= int64(len(magic)) {
+		return FormatZip, CompressionDeflate, err
+	}
+	return DetectFormat(magic), DetectCompression(magic), nil
 }
@@ -0,0 +1,59 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const (
+	testPrivateKey = `MIICWwIBAAKBgQC/feA9H71ak0hwXvltAn0+hkELPkqxvQKP6Q4auTVpFv8UNj97KpWutyrxeNKz8KAjb0V/E227WjOurgwZw+oN5rRzsckrvGhD8RIayINvPCscpW2lqUoLvqknl46DR/7lQP9qMJCY6nBZ7mmmw7wh04awdKK36SUUUMc+A0cPawIDAQABAoGAGmqyEZycUa950c64WBp8zrBUrslkIorxnIrJIFSmkp3SiKZHMaWZSqYILZG+d4ZdgSXrj3FNtQfnk1R9ZNyLICyqjRZ0sPVXTijzttqRqTS0VZyLgRP7Crc3feJlAxdzhyq8kqeoEUhsK4mnZ9I+D9lCgsMtlfLK0+pwmKA74CkCQQDnZHruvjvigkz0gMB0EfR7G8lcYSTbzAyTS5nzhVmQYRw3lUoj5fjgWPqXdS5VKGA4Zte8G7Ihfyyuw/jCv9fdAkEA09se7lCjSQdrYlZK1VIVhBwWLxvN3RcxI4tkpKli5r543kToWLOL1i0erBKkKTUm2le2WsseHtZyHbyfIU9z5wJAH7zTc72Z/yZ6IasrOoBf9SbJhqc4ZAFn1CgxdIpcz4XSVflfEu9vJG5v6KhE8583G2VXv9BYrWmBGnN8wlGH7QJAbJmHuox1l4sJHgi0JbQFOYqYSJ/NIMextdHPzqTSAQyksvPJ0yZ+yVSpw3Vu13zapNSPsu0qTI6LQvkc7ZtoAwJATZNuQuAhJXqb4gjVU14dGwvDxsqAu/0/Xj1eHP+C9msbFry7OUSCkyMZ4qQ4xz9w4sbzn51Tio0pgei9k0Bfcg==`
+	testPublicKey  = `MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQC/feA9H71ak0hwXvltAn0+hkELPkqxvQKP6Q4auTVpFv8UNj97KpWutyrxeNKz8KAjb0V/E227WjOurgwZw+oN5rRzsckrvGhD8RIayINvPCscpW2lqUoLvqknl46DR/7lQP9qMJCY6nBZ7mmmw7wh04awdKK36SUUUMc+A0cPawIDAQAB`
+)
+
+func TestReaderAt(t *testing.T) {
+	var b bytes.Buffer
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: &b}
+	var r io.Reader = strings.NewReader(strings.Repeat(`sane archiver `, 1000))
+	if err := w.AddReader(`test.txt`, &r); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := NewSaneReader(bytes.NewReader(b.Bytes()), testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, size, err := NewSaneReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()), testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(plain)) {
+		t.Fatalf(`expected size %d, got %d`, len(plain), size)
+	}
+	for _, off := range []int64{0, 1, 15, 16, 17, size / 2, size - 3} {
+		p := make([]byte, 3)
+		if n, err := at.ReadAt(p, off); n != len(p) {
+			t.Fatalf(`could not read at offset %d: %v`, off, err)
+		}
+		if !bytes.Equal(p, plain[off:off+3]) {
+			t.Errorf(`bytes at offset %d do not match the stream`, off)
+		}
+	}
+	z, err := zip.NewReader(at, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(z.File) != 1 || z.File[0].Name != `test.txt` {
+		t.Error(`archive listing is unexpected`)
+	}
+}
@@ -0,0 +1,204 @@
+package archiver
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// RemoteRetries limits how many times a failed remote read is attempted again.
+var RemoteRetries = 5
+
+// remoteChunkSize is the smallest range requested from a remote object by ReadAt.
+const remoteChunkSize = 4 * 1024 * 1024
+
+// File is an archive that can be read both sequentially and at random offsets.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// IsRemote returns true if target is a URL rather than a local path.
+func IsRemote(target string) bool {
+	return strings.Contains(target, `://`)
+}
+
+// Open returns a handle to a local archive or to a remote object identified by URL.
+func Open(target string) (File, error) {
+	if !IsRemote(target) {
+		in, err := os.Open(target)
+		if err != nil {
+			return nil, err
+		}
+		info, err := in.Stat()
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		if info.IsDir() {
+			in.Close()
+			return nil, fmt.Errorf(`<%s> is a directory`, target)
+		}
+		return &localFile{File: in, size: info.Size()}, nil
+	}
+	switch target[:strings.Index(target, `://`)] {
+	case `s3`:
+		return openS3(target)
+	}
+	return nil, fmt.Errorf(`URL <%s> uses an unsupported scheme`, RedactURL(target))
+}
+
+type localFile struct {
+	*os.File
+	size int64
+}
+
+func (l *localFile) Size() int64 { return l.size }
+
+// s3Object reads an S3 object with ranged requests, resuming after failures.
+type s3Object struct {
+	client *s3.S3
+	bucket string
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+
+	mu          sync.Mutex // Guards the chunk, because ReadAt may be called in parallel.
+	chunk       []byte
+	chunkOffset int64
+}
+
+func openS3(URL string) (*s3Object, error) {
+	l, err := parseS3URL(URL)
+	if err != nil {
+		return nil, err
+	}
+	o := &s3Object{client: s3.New(l.Session), bucket: l.Bucket, key: l.Key, chunkOffset: -1}
+	head, err := o.client.HeadObject(&s3.HeadObjectInput{Bucket: &o.bucket, Key: &o.key})
+	if err != nil {
+		return nil, err
+	}
+	o.size = aws.Int64Value(head.ContentLength)
+	return o, nil
+}
+
+func (o *s3Object) Size() int64 { return o.size }
+
+// get requests the object bytes in the inclusive range [from, to].
+func (o *s3Object) get(from, to int64) (io.ReadCloser, error) {
+	r := fmt.Sprintf(`bytes=%d-`, from)
+	if to >= 0 {
+		r += fmt.Sprintf(`%d`, to)
+	}
+	out, err := o.client.GetObject(&s3.GetObjectInput{
+		Bucket: &o.bucket,
+		Key:    &o.key,
+		Range:  &r,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// retry runs f until it succeeds, backing off a little longer after each failure.
+func (o *s3Object) retry(f func() error) (err error) {
+	for attempt := 0; attempt <= RemoteRetries; attempt++ {
+		if attempt > 0 {
+			slog.Warn(`Reading from S3 failed, retrying`, `bucket`, o.bucket, `key`, o.key, `attempt`, attempt, `error`, err)
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+		if err = f(); err == nil || err == io.EOF {
+			return err
+		}
+	}
+	return err
+}
+
+// Read streams the object sequentially, resuming from the last byte read when the connection drops.
+func (o *s3Object) Read(p []byte) (n int, err error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	err = o.retry(func() (err error) {
+		if o.body == nil {
+			if o.body, err = o.get(o.offset, -1); err != nil {
+				return err
+			}
+		}
+		n, err = o.body.Read(p)
+		o.offset += int64(n)
+		if err != nil && err != io.EOF {
+			o.body.Close()
+			o.body = nil
+			if n > 0 {
+				return nil // deliver what was read, resume on the next call
+			}
+		}
+		return err
+	})
+	if err == io.EOF && o.offset < o.size {
+		o.body.Close()
+		o.body = nil
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads whole chunks of the object and keeps the most recent one, so that
+// small sequential reads do not turn into a request each. Chunks are never modified
+// once read, so parallel calls only have to agree on which one is kept.
+func (o *s3Object) ReadAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		at := off + int64(n)
+		if at >= o.size {
+			return n, io.EOF
+		}
+		start := at - at%remoteChunkSize
+		o.mu.Lock()
+		chunk, chunkOffset := o.chunk, o.chunkOffset
+		o.mu.Unlock()
+		if start != chunkOffset {
+			end := start + remoteChunkSize
+			if end > o.size {
+				end = o.size
+			}
+			chunk = make([]byte, end-start)
+			err = o.retry(func() error {
+				body, err := o.get(start, end-1)
+				if err != nil {
+					return err
+				}
+				defer body.Close()
+				_, err = io.ReadFull(body, chunk)
+				return err
+			})
+			if err != nil {
+				return n, err
+			}
+			o.mu.Lock()
+			o.chunk, o.chunkOffset = chunk, start
+			o.mu.Unlock()
+		}
+		n += copy(p[n:], chunk[at-start:])
+	}
+	return n, nil
+}
+
+// Close releases the active connection, if any.
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package archiver
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+)
+
+// NewSealWriter encrypts anything written into it with the envelope used by archives:
+// a random nonce, a random symmetric key encrypted with the public key, and then the
+// AES-CTR stream. Use it to protect tarballs or database dumps without an inner archive.
+// Closing the writer does not close the output.
+func NewSealWriter(out io.Writer, base64PublicKey string) (io.WriteCloser, error) {
+	if len(base64PublicKey) == 0 {
+		return nil, fmt.Errorf(`cannot perform operations using an empty key`)
+	}
+	nonce, key, secret := MakeNonceKeySecret(base64PublicKey)
+	if _, err := out.Write(nonce); err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(secret); err != nil {
+		return nil, err
+	}
+	// TODO: cipher.NewOFB was used before, but that may cause problems with bit-rot.
+	return &cipher.StreamWriter{
+		S: cipher.NewCTR(SetupSymmetricCipherBlock(key), nonce), W: nopWriteCloser{out}}, nil
+}
+
+// NewOpenReader decrypts a stream written by NewSealWriter. Archives can be opened
+// the same way, which yields their inner zip or tar contents.
+func NewOpenReader(in io.Reader, base64PrivateKey string) (io.Reader, error) {
+	return NewSaneReader(in, base64PrivateKey)
+}
@@ -0,0 +1,42 @@
+package archiver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	message := bytes.Repeat([]byte(`sealed stream `), 1000)
+	var b bytes.Buffer
+	w, err := NewSealWriter(&b, testPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != HeaderSize+len(message) {
+		t.Errorf(`expected %d bytes, got %d`, HeaderSize+len(message), b.Len())
+	}
+	if bytes.Contains(b.Bytes(), []byte(`sealed`)) {
+		t.Error(`plain text leaked into the sealed stream`)
+	}
+	r, err := NewOpenReader(&b, testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, message) {
+		t.Error(`opened stream does not match`)
+	}
+	if _, err = NewSealWriter(&b, ``); err == nil {
+		t.Error(`empty key must be refused`)
+	}
+}
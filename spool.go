@@ -0,0 +1,86 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// entryBuffer holds contents of an archive entry until they can be written out,
+// either because their size must be known upfront or because they wait for their turn.
+type entryBuffer interface {
+	io.WriteCloser
+	Len() int64
+	Reader() (io.Reader, error)
+}
+
+// memoryBuffer keeps small entries in memory.
+type memoryBuffer struct {
+	bytes.Buffer
+}
+
+func (b *memoryBuffer) Len() int64 {
+	return int64(b.Buffer.Len())
+}
+
+func (b *memoryBuffer) Reader() (io.Reader, error) {
+	return &b.Buffer, nil
+}
+
+func (b *memoryBuffer) Close() error {
+	return nil
+}
+
+// spool keeps large entries or streams of unknown size in a temporary file. The file is
+// encrypted with a key that only exists in memory, so that archived data never touches
+// the disk in plain text.
+type spool struct {
+	file   *os.File
+	block  cipher.Block
+	nonce  []byte
+	writer io.Writer
+	size   int64
+}
+
+func newSpool() (s *spool, err error) {
+	key := make([]byte, aes.BlockSize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	s = &spool{block: SetupSymmetricCipherBlock(key), nonce: make([]byte, aes.BlockSize)}
+	if _, err = rand.Read(s.nonce); err != nil {
+		return nil, err
+	}
+	if s.file, err = ioutil.TempFile(``, `.sane-archiver-spool-*`); err != nil {
+		return nil, err
+	}
+	os.Remove(s.file.Name()) // the file lives until closed
+	s.writer = &cipher.StreamWriter{S: cipher.NewCTR(s.block, s.nonce), W: s.file}
+	return s, nil
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	n, err := s.writer.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *spool) Len() int64 {
+	return s.size
+}
+
+// Reader rewinds the spool and returns its decrypted contents.
+func (s *spool) Reader() (io.Reader, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &cipher.StreamReader{S: cipher.NewCTR(s.block, s.nonce), R: s.file}, nil
+}
+
+func (s *spool) Close() error {
+	return s.file.Close()
+}
@@ -2,38 +2,171 @@ package archiver
 
 import (
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // ErrS3URLError is a generic message indicating that provided S3 URL is improper.
 var ErrS3URLError = errors.New(`provided S3 URL does not follow the proper format: s3://<credentialID>:<credentialSecret>@<awsRegion>/<bucket>/<path>`)
 
-// UploadS3 pushes one file to AWS S3 bucket.
-func UploadS3(file string, URL string) error {
-	if strings.HasSuffix(URL, `/`) {
-		URL += filepath.Base(file)
+// S3Options adjust how an uploaded archive is stored. Zero values leave bucket defaults in place.
+type S3Options struct {
+	StorageClass         string        // STANDARD, STANDARD_IA, GLACIER, DEEP_ARCHIVE, etc.
+	ServerSideEncryption string        // AES256 or aws:kms.
+	KMSKeyID             string        // Key used with aws:kms encryption, bucket default if empty.
+	LockMode             string        // Object Lock retention mode: GOVERNANCE or COMPLIANCE.
+	LockPeriod           time.Duration // How long the object cannot be deleted or overwritten.
+	LegalHold            bool          // Place an Object Lock legal hold on the object.
+}
+
+// UploadOptions apply to uploads to any destination. Zero values upload with defaults.
+type UploadOptions struct {
+	S3       S3Options    // Used for s3:// destinations.
+	Progress *Progress    // Counts bytes sent for upload, if set.
+	Events   EventHandler // Receives UploadDone events of UploadAll, if set.
+}
+
+// Validate checks that options can be accepted by S3 before anything is uploaded.
+func (o S3Options) Validate() error {
+	switch o.StorageClass {
+	case ``, s3.StorageClassStandard, s3.StorageClassReducedRedundancy,
+		s3.StorageClassStandardIa, s3.StorageClassOnezoneIa,
+		s3.StorageClassIntelligentTiering, s3.StorageClassGlacier,
+		s3.StorageClassDeepArchive:
+	default:
+		return fmt.Errorf(`unknown S3 storage class %q`, o.StorageClass)
+	}
+	switch o.ServerSideEncryption {
+	case ``, s3.ServerSideEncryptionAes256:
+		if o.KMSKeyID != `` {
+			return errors.New(`S3 KMS key requires aws:kms server-side encryption`)
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf(`unknown S3 server-side encryption %q`, o.ServerSideEncryption)
+	}
+	switch o.LockMode {
+	case ``:
+		if o.LockPeriod != 0 {
+			return errors.New(`S3 object lock period requires a lock mode`)
+		}
+	case s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance:
+		if o.LockPeriod <= 0 {
+			return errors.New(`S3 object lock mode requires a positive lock period`)
+		}
+	default:
+		return fmt.Errorf(`unknown S3 object lock mode %q`, o.LockMode)
+	}
+	return nil
+}
+
+// apply copies the options into the upload request.
+func (o *S3Options) apply(in *s3manager.UploadInput, now time.Time) {
+	if o.StorageClass != `` {
+		in.StorageClass = aws.String(o.StorageClass)
+	}
+	if o.ServerSideEncryption != `` {
+		in.ServerSideEncryption = aws.String(o.ServerSideEncryption)
+	}
+	if o.KMSKeyID != `` {
+		in.SSEKMSKeyId = aws.String(o.KMSKeyID)
+	}
+	if o.LockMode != `` {
+		in.ObjectLockMode = aws.String(o.LockMode)
+		in.ObjectLockRetainUntilDate = aws.Time(now.Add(o.LockPeriod))
+	}
+	if o.LegalHold {
+		in.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
+}
+
+// isKMSAlias is true if the key is given by an alias name or alias ARN rather than the key itself.
+func isKMSAlias(key string) bool {
+	return strings.HasPrefix(key, `alias/`) || strings.Contains(key, `:alias/`)
+}
+
+// resolveKMSKey finds the ARN of the key an alias points to. S3 reports the key ARN, which
+// cannot be told from the alias alone. Empty if the alias cannot be described.
+func resolveKMSKey(sess *session.Session, alias string) string {
+	out, err := kms.New(sess).DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(alias)})
+	if err != nil || out.KeyMetadata == nil {
+		slog.Warn(`KMS key alias could not be resolved, only the encryption algorithm is verified`, `alias`, alias, `error`, err)
+		return ``
+	}
+	return aws.StringValue(out.KeyMetadata.Arn)
+}
+
+// verify makes sure that S3 stored the object the way it was asked to. The KMS key is the
+// key ID or ARN expected in place of an alias, empty if the alias could not be resolved.
+func (o *S3Options) verify(in *s3manager.UploadInput, head *s3.HeadObjectOutput, kmsKey string) error {
+	mismatch := func(property string, expected, actual interface{}) error {
+		return fmt.Errorf(`uploaded object has %s %v instead of %v`, property, actual, expected)
+	}
+	// S3 omits the storage class header for the STANDARD class.
+	if o.StorageClass != `` && o.StorageClass != s3.StorageClassStandard &&
+		aws.StringValue(head.StorageClass) != o.StorageClass {
+		return mismatch(`storage class`, o.StorageClass, aws.StringValue(head.StorageClass))
 	}
+	if o.ServerSideEncryption != `` &&
+		aws.StringValue(head.ServerSideEncryption) != o.ServerSideEncryption {
+		return mismatch(`server-side encryption`, o.ServerSideEncryption, aws.StringValue(head.ServerSideEncryption))
+	}
+	// S3 reports the full key ARN even if the key was given by its ID.
+	if kmsKey != `` && !strings.HasSuffix(aws.StringValue(head.SSEKMSKeyId), kmsKey) {
+		return mismatch(`KMS key`, kmsKey, aws.StringValue(head.SSEKMSKeyId))
+	}
+	if o.LockMode != `` {
+		if aws.StringValue(head.ObjectLockMode) != o.LockMode {
+			return mismatch(`object lock mode`, o.LockMode, aws.StringValue(head.ObjectLockMode))
+		}
+		if head.ObjectLockRetainUntilDate == nil ||
+			head.ObjectLockRetainUntilDate.Before(in.ObjectLockRetainUntilDate.Truncate(time.Second)) {
+			return mismatch(`object lock retention date`, *in.ObjectLockRetainUntilDate, aws.TimeValue(head.ObjectLockRetainUntilDate))
+		}
+	}
+	if o.LegalHold && aws.StringValue(head.ObjectLockLegalHoldStatus) != s3.ObjectLockLegalHoldStatusOn {
+		return mismatch(`legal hold status`, s3.ObjectLockLegalHoldStatusOn, aws.StringValue(head.ObjectLockLegalHoldStatus))
+	}
+	return nil
+}
+
+// s3Location points to an object within an S3 bucket.
+type s3Location struct {
+	Session *session.Session
+	Bucket  string
+	Key     string
+}
+
+// parseS3URL opens an AWS session for the bucket and the object key encoded in the URL.
+func parseS3URL(URL string) (*s3Location, error) {
 	u, err := url.Parse(URL)
 	if err != nil {
-		return err
+		// the parse error repeats the URL along with its credentials
+		return nil, fmt.Errorf(`%w: <%s>`, ErrS3URLError, RedactURL(URL))
+	}
+	if u.Scheme != `s3` || u.User == nil {
+		return nil, ErrS3URLError
 	}
-	i := strings.Index(u.Path, "/")
 	password, ok := u.User.Password()
-	if u.Scheme != `s3` || i == -1 || u.User == nil || !ok {
-		return ErrS3URLError
+	p := strings.TrimPrefix(u.Path, `/`)
+	i := strings.Index(p, `/`)
+	if !ok || i < 1 {
+		return nil, ErrS3URLError
 	}
-	bucketName, keyName := u.Path[:i+1], u.Path[i+1:]
 
-	// user := &u.User
 	retries := 3
 	sess, err := session.NewSession(&aws.Config{
 		Region:      aws.String(u.Host),
@@ -41,21 +174,106 @@ func UploadS3(file string, URL string) error {
 		MaxRetries:  &retries,
 	})
 	if err != nil {
-		return ErrS3URLError
+		return nil, ErrS3URLError
+	}
+	return &s3Location{Session: sess, Bucket: p[:i], Key: p[i+1:]}, nil
+}
+
+// UploadResult reports the outcome of uploading a file to one destination.
+type UploadResult struct {
+	URL string
+	Err error
+}
+
+// RedactURL hides credentials embedded in a destination URL, so that it can be logged.
+// A URL that cannot be parsed loses everything between the scheme and the last @.
+func RedactURL(URL string) string {
+	u, err := url.Parse(URL)
+	if err != nil {
+		scheme := strings.Index(URL, `://`)
+		at := strings.LastIndex(URL, `@`)
+		if scheme < 0 || at < scheme {
+			return `<unparsable URL>`
+		}
+		return URL[:scheme+3] + `***` + URL[at:]
+	} else if u.User == nil {
+		return URL
+	}
+	u.User = nil
+	return strings.Replace(u.String(), `://`, `://***@`, 1)
+}
+
+// Upload pushes one file to the destination URL, choosing the uploader by the URL scheme.
+func Upload(file string, URL string, options *UploadOptions) error {
+	if strings.HasPrefix(URL, `s3://`) {
+		return UploadS3(file, URL, options)
+	}
+	return fmt.Errorf(`URL <%s> uses an unsupported scheme`, RedactURL(URL))
+}
+
+// UploadAll pushes one file to all destinations concurrently. Results follow the order of URLs.
+func UploadAll(file string, URLs []string, options *UploadOptions) []UploadResult {
+	if options == nil {
+		options = &UploadOptions{}
+	}
+	results := make([]UploadResult, len(URLs))
+	var wg sync.WaitGroup
+	for i, URL := range URLs {
+		wg.Add(1)
+		go func(i int, URL string) {
+			defer wg.Done()
+			results[i] = UploadResult{URL: URL, Err: Upload(file, URL, options)}
+			options.Events.emit(UploadDone{File: file, URL: RedactURL(URL), Err: results[i].Err})
+		}(i, URL)
+	}
+	wg.Wait()
+	return results
+}
+
+// UploadS3 pushes one file to AWS S3 bucket. Options may be nil.
+func UploadS3(file string, URL string, options *UploadOptions) error {
+	if strings.HasSuffix(URL, `/`) {
+		URL += filepath.Base(file)
+	}
+	if options == nil {
+		options = &UploadOptions{}
+	}
+	if err := options.S3.Validate(); err != nil {
+		return err
+	}
+	l, err := parseS3URL(URL)
+	if err != nil {
+		return err
 	}
 	handle, err := os.Open(file)
 	if err != nil {
 		return err
 	}
 	defer handle.Close()
-	uploader := s3manager.NewUploader(sess)
+	uploader := s3manager.NewUploader(l.Session)
 	upParams := &s3manager.UploadInput{
-		Bucket: &bucketName,
-		Key:    &keyName,
+		Bucket: &l.Bucket,
+		Key:    &l.Key,
 		Body:   handle,
 	}
+	options.S3.apply(upParams, time.Now())
+	kmsKey := options.S3.KMSKeyID
+	if isKMSAlias(kmsKey) {
+		kmsKey = resolveKMSKey(l.Session, kmsKey)
+	}
 	// Perform an upload.
-	_, err = uploader.Upload(upParams)
+	if options.Progress != nil {
+		_, err = uploader.Upload(upParams, s3manager.WithUploaderRequestOptions(options.Progress.countSent))
+	} else {
+		_, err = uploader.Upload(upParams)
+	}
+	if err == nil {
+		var head *s3.HeadObjectOutput
+		head, err = s3.New(l.Session).HeadObject(&s3.HeadObjectInput{Bucket: &l.Bucket, Key: &l.Key})
+		if err == nil {
+			err = options.S3.verify(upParams, head, kmsKey)
+		}
+	}
 	// spew.Dump(result)
 	// Perform upload with options different than the those in the Uploader.
 	// result, err := uploader.Upload(upParams, func(u *s3manager.Uploader) {
@@ -63,9 +281,9 @@ func UploadS3(file string, URL string) error {
 	// 	u.LeavePartsOnError = true    // Don't delete the parts if the upload fails.
 	// })
 	if err == nil {
-		log.Printf("[S3] Successfully uploaded %s to %s.", file, u.Path)
+		slog.Info(`Uploaded to S3`, `path`, file, `bucket`, l.Bucket, `key`, l.Key)
 	} else {
-		log.Printf("[S3] There was an error uploading %s to %s: %s.", file, u.Path, err.Error())
+		slog.Warn(`Upload to S3 failed`, `path`, file, `bucket`, l.Bucket, `key`, l.Key, `error`, err)
 	}
 	return err
 }
@@ -1,93 +1,354 @@
 package archiver
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// ErrorPolicy decides what the walker does with paths that cannot be stored.
+type ErrorPolicy uint8
+
+const (
+	// ErrorAbort stops walking at the first error.
+	ErrorAbort ErrorPolicy = iota
+	// ErrorSkip leaves the failed path out and carries on.
+	ErrorSkip
+	// ErrorSkipAndRecord also lists failed paths in the ErrorReportName archive entry.
+	ErrorSkipAndRecord
+)
+
+// ErrorPolicies maps policy names, as used on the command line, to policies.
+var ErrorPolicies = map[string]ErrorPolicy{
+	`abort`:           ErrorAbort,
+	`skip`:            ErrorSkip,
+	`skip-and-record`: ErrorSkipAndRecord,
+}
+
+// ErrorReportName is the archive entry that lists paths left out because of errors.
+const ErrorReportName = `.sane-archiver/errors.txt`
+
+// SkippedPath is a path that could not be stored, or was stored partially, and why.
+type SkippedPath struct {
+	Path string
+	Err  error
+}
+
+func (s SkippedPath) String() string {
+	return fmt.Sprintf(`%s: %s`, s.Path, s.Err)
+}
+
+// WriteErrorReport adds an entry listing skipped paths to the archive.
+func WriteErrorReport(w *SaneWriter, skipped []SkippedPath) error {
+	report := &strings.Builder{}
+	for _, s := range skipped {
+		fmt.Fprintln(report, s)
+	}
+	var r io.Reader = strings.NewReader(report.String())
+	return w.AddReader(ErrorReportName, &r)
+}
+
 // SaneDirectoryWalker feeds files and directories into writer.
 type SaneDirectoryWalker struct {
-	Target         string
-	Dryrun, Master bool
+	Target string
+	Dryrun bool
+	Git    GitSelection // Branches and tags of git repositories to store.
+	Bundle bool         // Store git repositories as bundles with full history instead of branch tarballs.
+	Dirty  bool         // Also store uncommitted and untracked work found in git working trees.
+	Ignore IgnoreRules  // Paths to skip.
+	Policy ErrorPolicy  // What to do with paths that cannot be stored.
+
+	// Skipped lists paths left out because of errors, unless the policy is ErrorAbort.
+	Skipped []SkippedPath
+	ignored *ignoreList
+	events  EventHandler // Taken from the writer.
+}
+
+// signal logs a message with attributes as key-value pairs, tagged with the walked target.
+func (d *SaneDirectoryWalker) signal(level slog.Level, message string, args ...interface{}) {
+	args = append(args, `target`, d.Target)
+	if d.Dryrun {
+		args = append(args, `dryrun`, true)
+	}
+	slog.Log(context.Background(), level, message, args...)
 }
 
-func (d *SaneDirectoryWalker) signal(message string, args ...interface{}) {
-	message = fmt.Sprintf(` @%s %s`, d.Target, message)
+// explain is the level of messages that tell why paths are skipped and references chosen.
+// They are the point of dry runs, so dry runs log them at the default level.
+func (d *SaneDirectoryWalker) explain() slog.Level {
 	if d.Dryrun {
-		message = `DRYRUN >` + message
+		return slog.LevelInfo
 	}
-	log.Printf(message, args...)
+	return slog.LevelDebug
 }
 
-func (d *SaneDirectoryWalker) getGitBranches(p string) ([]string, error) {
-	l, err := GitBranchList(p)
+// getGitReferences picks branches and tags according to the selection, explaining each choice.
+// Linked worktrees only offer the branch they have checked out, the rest belongs to the main repository.
+func (d *SaneDirectoryWalker) getGitReferences(p string, kind GitRepositoryKind) ([]GitReference, error) {
+	l, err := GitReferences(p)
 	if err != nil {
-		return l, err
+		return nil, err
 	}
-	if d.Master {
-		for _, branch := range l {
-			if branch == `master` {
-				return []string{`master`}, nil
+	head, err := GitHeadBranch(p)
+	if err != nil {
+		return nil, err
+	}
+	if kind == GitLinkedWorktree {
+		checkedOut := make([]GitReference, 0, 1)
+		for _, ref := range l {
+			if !ref.Tag && ref.Name == head {
+				checkedOut = append(checkedOut, ref)
 			}
 		}
-		d.signal(`Skipping directory <%s> because it does not have a master branch.`, p, l)
-		return []string{}, nil
+		l = checkedOut
+	}
+	l, reasons := d.Git.Select(l, head, time.Now())
+	for _, reason := range reasons {
+		d.signal(d.explain(), `Git reference selection`, `repository`, p, `reason`, reason)
 	}
-	return l, err
+	return l, nil
 }
 
-func (d *SaneDirectoryWalker) processGitDirectory(w *SaneWriter, path string) error {
-	l, err := d.getGitBranches(path)
+// addStream stores contents of the reader as an archive entry and closes the reader.
+func (d *SaneDirectoryWalker) addStream(w *SaneWriter, name string, r io.ReadCloser) error {
+	var reader io.Reader = r
+	err := w.AddReader(name, &reader)
+	r.Close()
+	return err
+}
+
+func (d *SaneDirectoryWalker) processGitWorktree(w *SaneWriter, path string) error {
+	changes, err := GitWorktreeChanges(path)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		d.signal(slog.LevelDebug, `Working tree of git repository is clean`, `repository`, path)
+		return nil
+	}
+	d.signal(slog.LevelInfo, `Detected uncommitted changes in git repository`, `repository`, path, `changes`, len(changes))
+	if !d.Dryrun {
+		if err := d.addStream(w, filepath.Join(path, `worktree.tar`), GitWorktreeReader(path)); err != nil {
+			d.signal(slog.LevelDebug, `Working tree of git repository could not be accessed`, `repository`, path)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *SaneDirectoryWalker) processGitBundle(w *SaneWriter, path string) error {
+	subs, err := GitSubmodules(path)
+	if err != nil {
+		return err
+	}
+	d.signal(slog.LevelInfo, `Storing git repository as a bundle`, `repository`, path, `submodules`, subs)
+	if d.Dryrun {
+		return nil
+	}
+	// submodules follow the parent, so that they are restored into its working tree
+	for _, p := range append([]string{path}, subs...) {
+		if p != path {
+			p = filepath.Join(path, p)
+		}
+		if err := d.addStream(w, p+`.bundle`, GitBundleReader(p)); err != nil {
+			d.signal(slog.LevelDebug, `Git repository could not be accessed`, `repository`, p)
+			return err
+		}
+	}
+	if w.Manifest != nil || d.events != nil {
+		// a bundle carries every branch and tag of the repository
+		l, err := GitReferences(path)
+		if err != nil {
+			// the bundles are already stored, only their description is missing
+			d.signal(slog.LevelWarn, `Git references of the bundle could not be listed`, `repository`, path, `error`, err)
+			return nil
+		}
+		if w.Manifest != nil {
+			w.Manifest.addGitRefs(path, l)
+		}
+		for _, ref := range l {
+			d.events.emit(GitReferenceArchived{Repository: path, Reference: ref, Bundle: true})
+		}
+	}
+	return nil
+}
+
+func (d *SaneDirectoryWalker) processGitReferences(w *SaneWriter, path string, kind GitRepositoryKind) error {
+	l, err := d.getGitReferences(path, kind)
 	if err != nil {
 		return err
 	}
-	d.signal(`Detected git repository at <%s> with branches %q.`, path, l)
+	if len(l) == 0 {
+		d.signal(slog.LevelWarn, `No branches or tags of git repository were selected`, `repository`, path)
+	}
 	if !d.Dryrun {
-		for _, branch := range l {
-			cmd, r, gw := GitArchiveReader(path, branch)
-			go func() {
-				cmd.Run()
-				gw.Close()
-			}()
-			if err := w.AddReader(strings.TrimSuffix(path, `.git`)+`-`+branch+`.tar`, &r); err != nil {
-				d.signal("Git repository <%s> could not be accessed.", path)
+		for _, ref := range l {
+			name, r := filepath.Join(path, `branches`, ref.Name+`.tar`), GitArchiveReader
+			if ref.Tag {
+				name, r = filepath.Join(path, `tags`, ref.Name+`.tar`), GitTagArchiveReader
+			}
+			if err := d.addStream(w, name, r(path, ref.Name)); err != nil {
+				d.signal(slog.LevelDebug, `Git repository could not be accessed`, `repository`, path)
 				return err
 			}
+			d.events.emit(GitReferenceArchived{Repository: path, Reference: ref})
+		}
+		if w.Manifest != nil {
+			w.Manifest.addGitRefs(path, l)
+		}
+	}
+	return nil
+}
+
+// processGitDirectory stores a git repository found at path. Its entries are named
+// after the repository path: branches/<branch>.tar and worktree.tar are placed
+// inside it along with tags/<tag>.tar, while bundles are stored as <path>.bundle.
+func (d *SaneDirectoryWalker) processGitDirectory(w *SaneWriter, path string) error {
+	kind, err := DetectGitRepository(path)
+	if errors.Is(err, ErrNotGitRepository) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	d.signal(slog.LevelInfo, `Detected git repository`, `repository`, path, `kind`, kind.String())
+	if d.Dirty && kind != GitBareRepository {
+		if err = d.processGitWorktree(w, path); err != nil {
+			return err
 		}
 	}
+	if d.Bundle {
+		err = d.processGitBundle(w, path)
+	} else {
+		err = d.processGitReferences(w, path, kind)
+	}
+	if err != nil {
+		return err
+	}
 	// do not recurse within git directories
 	return filepath.SkipDir
 }
 
+// processDirectory stores git repositories or picks up ignore rules of a regular directory.
+func (d *SaneDirectoryWalker) processDirectory(w *SaneWriter, path string) error {
+	if err := d.processGitDirectory(w, path); err != nil {
+		return err
+	}
+	if err := d.ignored.load(path, IgnoreFileName); err != nil {
+		return err
+	}
+	if d.Ignore.RespectGitignore {
+		return d.ignored.load(path, `.gitignore`)
+	}
+	return nil
+}
+
+// fail applies the error policy: it either returns the error to stop walking
+// or remembers the path and returns the value that lets walking continue.
+// Partially written entries always stop walking, because the archive is damaged.
+func (d *SaneDirectoryWalker) fail(path string, err error, resume error) error {
+	if d.Policy == ErrorAbort || errors.Is(err, ErrPartialEntry) {
+		return err
+	}
+	d.signal(slog.LevelWarn, `Skipping path`, `path`, path, `error`, err)
+	d.Skipped = append(d.Skipped, SkippedPath{Path: path, Err: err})
+	d.events.emit(PathSkipped{Path: path, Err: err})
+	return resume
+}
+
+// Count finds how many regular files Walk would add and their total size, for progress
+// reporting. Git repositories are left out, because their size is only known once they are
+// archived. Paths that cannot be accessed are left out as well, Walk deals with them.
+func (d *SaneDirectoryWalker) Count() (files int64, bytes int64, err error) {
+	ignored := newIgnoreList(d.Target, d.Ignore)
+	err = filepath.Walk(d.Target,
+		func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			} else if ignored.skip(file, info.IsDir()) != `` {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			} else if info.IsDir() {
+				if _, err = DetectGitRepository(file); err == nil {
+					return filepath.SkipDir
+				}
+				if err = ignored.load(file, IgnoreFileName); err == nil && d.Ignore.RespectGitignore {
+					err = ignored.load(file, `.gitignore`)
+				}
+				if err != nil {
+					return filepath.SkipDir
+				}
+			} else if info.Mode().IsRegular() {
+				files++
+				bytes += info.Size()
+			}
+			return nil
+		})
+	return files, bytes, err
+}
+
 // Walk feeds discovered objects into writer.
 func (d *SaneDirectoryWalker) Walk(w *SaneWriter) (err error) {
+	d.ignored, d.events = newIgnoreList(d.Target, d.Ignore), w.Events
 	err = filepath.Walk(d.Target,
 		func(file string, info os.FileInfo, err error) error {
 			if err != nil {
-				d.signal("Path <%s> could not be accessed.", file)
-				return err
+				d.signal(slog.LevelDebug, `Path could not be accessed`, `path`, file)
+				return d.fail(file, err, nil)
+			} else if reason := d.ignored.skip(file, info.IsDir()); reason != `` {
+				d.signal(d.explain(), `Skipping ignored path`, `path`, file, `reason`, reason)
+				d.events.emit(PathSkipped{Path: file, Reason: reason})
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
 			} else if info.IsDir() {
-				return d.processGitDirectory(w, file)
+				err = d.processDirectory(w, file)
+				if err != nil && err != filepath.SkipDir {
+					return d.fail(file, err, filepath.SkipDir)
+				} else if err == nil && w.Format == FormatTar && !d.Dryrun {
+					// tar archives keep directories, so that empty ones and their permissions survive
+					if err = w.AddFile(file); err != nil {
+						return d.fail(file, err, filepath.SkipDir)
+					}
+				}
+				return err
 			} else {
-				d.signal("Adding <%s> to the archive.", file)
 				if d.Dryrun {
-					d.signal("Skipping file <%s> for dryrun.", file)
+					d.signal(slog.LevelInfo, `Skipping file for dryrun`, `path`, file)
 					return nil
-				} else if err := w.AddFile(file); err != nil {
-					d.signal("File <%s> could not be accessed.", file)
-					return err
 				}
-				d.signal("File <%s> successfully added.", file)
+				d.signal(slog.LevelDebug, `Adding file to the archive`, `path`, file)
+				if err := w.AddFile(file); err != nil {
+					d.signal(slog.LevelDebug, `File could not be accessed`, `path`, file)
+					return d.fail(file, err, nil)
+				}
 			}
 			return nil
 		})
-	if err == nil {
-		d.signal(`[DONE] "%s" was fully archived!`, d.Target)
+	// files compressed in the background fail only once they are flushed
+	failed, ferr := w.Flush()
+	for _, f := range failed {
+		if e := d.fail(f.Path, f.Err, nil); e != nil && err == nil {
+			err = e
+		}
+	}
+	if ferr != nil && err == nil {
+		err = ferr
+	}
+	if err == nil && len(d.Skipped) == 0 {
+		d.signal(slog.LevelInfo, `Target was fully archived`)
+	} else if err == nil {
+		d.signal(slog.LevelWarn, `Target was archived, but paths were skipped because of errors`, `skipped`, len(d.Skipped))
 	} else {
-		d.signal(`"%s" could not be fully processed. Reason: %s.`, d.Target, err)
+		d.signal(slog.LevelError, `Target could not be fully processed`, `error`, err)
 	}
 	return err
 }
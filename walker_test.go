@@ -0,0 +1,183 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testWalk packs the target and lists archived files relative to it.
+func testWalk(t *testing.T, d *SaneDirectoryWalker) []string {
+	var b bytes.Buffer
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: &b}
+	if err := d.Walk(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, size, err := NewSaneReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()), testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := unrootPath.ReplaceAllString(filepath.ToSlash(d.Target), ``) + `/`
+	names := make([]string, 0)
+	for _, f := range z.File {
+		names = append(names, strings.TrimPrefix(f.Name, root))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWalkIgnoreRules(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-walk-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for p, content := range map[string]string{
+		`a.txt`:                       `a`,
+		`node_modules/x.js`:           `x`,
+		`cache/CACHEDIR.TAG`:          string(cacheDirTagSignature) + "\n",
+		`cache/data.bin`:              `0`,
+		`sub/` + IgnoreFileName:       "# logs\n*.log\n!keep.log\n",
+		`sub/drop.log`:                `drop`,
+		`sub/keep.log`:                `keep`,
+		`other/drop.log`:              `not ignored outside of sub`,
+		`other/.gitignore`:            "*.txt\n",
+		`other/gitignored.txt`:        `skipped only when asked`,
+		`other/node_modules/again.js`: `x`,
+	} {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := testWalk(t, &SaneDirectoryWalker{
+		Target: dir,
+		Ignore: IgnoreRules{Exclude: []string{`node_modules/`}, RespectGitignore: true},
+	})
+	expected := `a.txt,other/.gitignore,other/drop.log,sub/.saneignore,sub/keep.log`
+	if strings.Join(names, `,`) != expected {
+		t.Errorf(`expected %s, got %s`, expected, strings.Join(names, `,`))
+	}
+}
+
+func TestWalkErrorPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-walk-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, `a.txt`), []byte(`a`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(filepath.Join(dir, `missing`), filepath.Join(dir, `broken`)); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &SaneDirectoryWalker{Target: dir}
+	if err = d.Walk(&SaneWriter{PublicKey: testPublicKey, Writer: ioutil.Discard}); err == nil {
+		t.Error(`walk should have been aborted`)
+	}
+
+	d = &SaneDirectoryWalker{Target: dir, Policy: ErrorSkipAndRecord}
+	names := testWalk(t, d)
+	if len(d.Skipped) != 1 || d.Skipped[0].Path != filepath.Join(dir, `broken`) {
+		t.Errorf(`expected the broken link to be skipped, got %q`, d.Skipped)
+	}
+	if strings.Join(names, `,`) != `a.txt` {
+		t.Errorf(`unexpected archive contents %q`, names)
+	}
+}
+
+// failingReader returns some contents and then an error.
+type failingReader struct{ n int }
+
+func (f *failingReader) Read(b []byte) (int, error) {
+	if f.n > 0 {
+		f.n = 0
+		return copy(b, `partial`), nil
+	}
+	return 0, errors.New(`device is gone`)
+}
+
+func TestWalkPartialEntry(t *testing.T) {
+	w := &SaneWriter{PublicKey: testPublicKey, Writer: ioutil.Discard}
+	var r io.Reader = &failingReader{n: 1}
+	err := w.AddReader(`partial.txt`, &r)
+	if !errors.Is(err, ErrPartialEntry) {
+		t.Fatalf(`expected a partially written entry, got %v`, err)
+	}
+	for _, policy := range []ErrorPolicy{ErrorSkip, ErrorSkipAndRecord} {
+		d := &SaneDirectoryWalker{Policy: policy}
+		if d.fail(`partial.txt`, err, nil) != err || len(d.Skipped) != 0 {
+			t.Errorf(`policy %d should not skip a partially written entry`, policy)
+		}
+	}
+}
+
+func TestWalkDryRunExplains(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-walk-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, `node_modules`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	repository, _ := testGitRepository(t)
+
+	var log bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil))) // at the default level
+	for _, target := range []string{dir, repository} {
+		d := &SaneDirectoryWalker{Target: target, Dryrun: true, Ignore: IgnoreRules{Exclude: []string{`node_modules/`}}}
+		if err = d.Walk(&SaneWriter{PublicKey: testPublicKey, Writer: ioutil.Discard}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{`Skipping ignored path`, `reason=`, `Git reference selection`} {
+		if !strings.Contains(log.String(), expected) {
+			t.Errorf(`dry run should log %q, got %s`, expected, log.String())
+		}
+	}
+}
+
+func TestWalkIncludeOverridesIgnoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `sane-archiver-walk-`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		IgnoreFileName: "*.log\n",
+		`drop.log`:     `drop`,
+		`keep.log`:     `keep`,
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := testWalk(t, &SaneDirectoryWalker{Target: dir, Ignore: IgnoreRules{Include: []string{`keep.log`}}})
+	if strings.Join(names, `,`) != `.saneignore,keep.log` {
+		t.Errorf(`expected --include to keep keep.log, got %q`, names)
+	}
+}
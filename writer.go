@@ -1,19 +1,33 @@
 package archiver
 
 import (
+	"archive/tar"
 	"archive/zip"
-	"crypto/cipher"
+	"bufio"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"time"
 )
 
+// ErrEmptyArchive indicates that an archive was closed before anything was added to it.
+var ErrEmptyArchive = errors.New(`no files were added to the archive`)
+
+// ErrPartialEntry indicates that reading failed after an entry was started. The archive
+// is left damaged, so the entry cannot be skipped whatever the error policy is.
+var ErrPartialEntry = errors.New(`entry was partially written`)
+
+func partialEntry(name string, err error) error {
+	return fmt.Errorf(`%w <%s>: %w`, ErrPartialEntry, name, err)
+}
+
 var unrootPath = regexp.MustCompile(`^\.*\/+`)
 
 // SaneWriter is a wrapped writer.
@@ -22,35 +36,57 @@ type SaneWriter struct {
 	PublicKey string // Base64-encoded public key used for encryption.
 	Hash      hash.Hash
 	Size      uint64
+	Format    Format  // Layout of archive contents, zip unless specified.
+	Padding   Padding // Filler that hides the size of contents, none unless specified.
+
+	Compression      Compression // Method used for archived files, deflate unless specified.
+	CompressionLevel int         // Level of the compression method, zero for its default.
+	CompressAll      bool        // Compress every zip entry, even those that look already compressed.
+	Jobs             int         // Compress this many files at once, see Flush.
+
+	Manifest *Manifest    // Written into the archive on Close, if set. Files are recorded as they are added.
+	Progress *Progress    // Counts added files and bytes, if set.
+	Events   EventHandler // Receives events of this writer and walkers feeding it, if set.
 
-	headerReady   bool
-	cipherHandle  *cipher.StreamWriter
-	archiveHandle *zip.Writer
+	headerReady    bool
+	cipherHandle   io.WriteCloser
+	archiveHandle  *zip.Writer
+	compressHandle io.WriteCloser
+	tarHandle      *tar.Writer
+	hardLinks      map[fileIdentity]string
+	pipeline       *pipeline
+	failed         []SkippedPath
 }
 
 // writeHeader prepares everything neccessary for writing the encrypted file.
 func (w *SaneWriter) writeHeader() (err error) {
 	if !w.headerReady {
-		if len(w.PublicKey) == 0 {
-			return fmt.Errorf(`cannot perform operations using an empty key`)
+		if err = w.Compression.ValidateLevel(w.CompressionLevel); err != nil {
+			return err
 		}
-		nonce, key, secret := MakeNonceKeySecret(w.PublicKey)
 		w.Hash = md5.New()
-		fork := io.MultiWriter(w.Hash, w.Writer)
-		nA, err := fork.Write(nonce)
-		if err != nil {
-			return err
+		if w.Padding.Policy == PadNone {
+			w.cipherHandle, err = NewSealWriter(io.MultiWriter(w.Hash, w.Writer), w.PublicKey)
+		} else {
+			w.cipherHandle, err = NewPaddedSealWriter(io.MultiWriter(w.Hash, w.Writer), w.PublicKey, w.Padding)
 		}
-		nB, err := fork.Write(secret)
 		if err != nil {
 			return err
 		}
-
-		w.Size = uint64(nA + nB)
-		// TODO: cipher.NewOFB was used before, but that may cause problems with bit-rot.
-		w.cipherHandle = &cipher.StreamWriter{
-			S: cipher.NewCTR(SetupSymmetricCipherBlock(key), nonce), W: fork}
-		w.archiveHandle = zip.NewWriter(w.cipherHandle)
+		w.Size = HeaderSize
+		if w.Format == FormatTar {
+			w.compressHandle, err = compressStream(w.cipherHandle, w.Compression, w.CompressionLevel, w.Jobs)
+			if err != nil {
+				return err
+			}
+			w.tarHandle = tar.NewWriter(w.compressHandle)
+			w.hardLinks = make(map[fileIdentity]string)
+		} else {
+			w.archiveHandle = zip.NewWriter(w.cipherHandle)
+			if method := w.Compression.zipMethod(); method != zip.Store {
+				w.archiveHandle.RegisterCompressor(method, zipCompressor(w.Compression, w.CompressionLevel))
+			}
+		}
 		w.headerReady = true
 	}
 	return err
@@ -62,32 +98,163 @@ func (w *SaneWriter) AddFile(target string) (err error) {
 	if err != nil {
 		return err
 	}
+	if w.Format == FormatTar {
+		return w.addTarFile(target)
+	}
 	in, err := os.Open(target)
 	if err != nil {
 		return err
 	}
-	defer in.Close()
 	info, err := in.Stat()
 	if err != nil {
+		in.Close()
 		return err
 	}
 	header, err := zip.FileInfoHeader(info)
 	if err != nil {
+		in.Close()
 		return err
 	}
 	header.Name = unrootPath.ReplaceAllString(path.Clean(target), "")
 	header.Comment = `Created by sane-archiver.`
-	header.Method = zip.Deflate
+	if w.Jobs > 1 {
+		return w.enqueue(target, header, in)
+	}
+	defer in.Close()
+	r := w.chooseMethod(header, w.Progress.reader(in))
 	f, err := w.archiveHandle.CreateHeader(header)
 	if err != nil {
 		return err
 	}
-	n, err := io.Copy(f, in)
+	dst, h := w.checksum(f)
+	n, err := io.Copy(dst, r)
+	if err != nil {
+		return partialEntry(target, err)
+	}
+	w.Size += uint64(n)
+	w.record(header.Name, n, header.Modified, h)
+	w.added(target, n)
+	return nil
+}
+
+// chooseMethod sets compression method of a zip entry. Files that look already
+// compressed are stored, unless everything must be compressed. The returned reader
+// must be used in place of the given one, because its beginning is sampled.
+func (w *SaneWriter) chooseMethod(header *zip.FileHeader, in io.Reader) io.Reader {
+	header.Method = w.Compression.zipMethod()
+	if w.CompressAll || header.Method == zip.Store {
+		return in
+	}
+	r := bufio.NewReaderSize(in, entropySampleSize)
+	sample, _ := r.Peek(entropySampleSize)
+	if isIncompressible(header.Name, sample) {
+		header.Method = zip.Store
+	}
+	return r
+}
+
+// addTarFile stores any kind of file along with its ownership, permissions, times and
+// extended attributes. Directories, symbolic links and device nodes are stored without
+// following them, while hard links are stored as references to the first name seen.
+func (w *SaneWriter) addTarFile(target string) error {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	link := ``
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(target); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Format = tar.FormatPAX
+	header.Name = unrootPath.ReplaceAllString(path.Clean(filepath.ToSlash(target)), "")
+	if info.IsDir() {
+		header.Name += `/`
+	}
+	if id, ok := hardLinkIdentity(info); ok {
+		if first, seen := w.hardLinks[id]; seen {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, first, 0
+		} else {
+			w.hardLinks[id] = header.Name
+		}
+	}
+	xattrs, err := readXattrs(target)
+	if err != nil {
+		return fmt.Errorf(`could not read extended attributes: %w`, err)
+	}
+	for name, value := range xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords[`SCHILY.xattr.`+name] = value
+	}
+	if header.Typeflag != tar.TypeReg {
+		return w.tarHandle.WriteHeader(header)
+	}
+	in, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err = w.tarHandle.WriteHeader(header); err != nil {
+		return err
+	}
+	// the file may have changed since it was examined, but the size is already recorded
+	dst, h := w.checksum(w.tarHandle)
+	n, err := io.CopyN(dst, w.Progress.reader(in), header.Size)
 	if err != nil {
+		return partialEntry(target, err)
+	}
+	w.Size += uint64(n)
+	w.record(header.Name, n, header.ModTime, h)
+	w.added(target, n)
+	return nil
+}
+
+// addTarReader stores a stream as a regular file. The stream is spooled first,
+// because tar entries must declare their size before contents.
+func (w *SaneWriter) addTarReader(name string, target io.Reader) error {
+	s, err := newSpool()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if _, err = io.Copy(s, target); err != nil {
+		return err
+	}
+	return w.addTarBuffer(name, s)
+}
+
+// addTarBuffer stores contents of a buffer as a regular file.
+func (w *SaneWriter) addTarBuffer(name string, s entryBuffer) error {
+	contents, err := s.Reader()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     s.Len(),
+		Mode:     0664,
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	}
+	if err = w.tarHandle.WriteHeader(header); err != nil {
 		return err
 	}
+	dst, h := w.checksum(w.tarHandle)
+	n, err := io.Copy(dst, w.Progress.reader(contents))
+	if err != nil {
+		return partialEntry(name, err)
+	}
 	w.Size += uint64(n)
-	log.Printf("File <%s> was added.", target)
+	w.record(name, n, header.ModTime, h)
+	w.added(name, n)
 	return nil
 }
 
@@ -97,32 +264,65 @@ func (w *SaneWriter) AddReader(name string, target *io.Reader) (err error) {
 	if err != nil {
 		return err
 	}
+	name = unrootPath.ReplaceAllString(path.Clean(filepath.ToSlash(name)), "")
+	if w.Format == FormatTar {
+		return w.addTarReader(name, *target)
+	}
+	// streams are written right away, so files compressed in the background must go first
+	if err = w.stopPipeline(); err != nil {
+		return err
+	}
 	header := &zip.FileHeader{
 		Name:     name,
 		Comment:  `Created by sane-archiver.`,
 		Modified: time.Now(),
 		NonUTF8:  false,
-		Method:   zip.Deflate,
 	}
-	// f, err := w.archiveHandle.Create(name)
+	r := w.chooseMethod(header, w.Progress.reader(*target))
 	f, err := w.archiveHandle.CreateHeader(header)
 	if err != nil {
 		return err
 	}
-	n, err := io.Copy(f, *target)
+	dst, h := w.checksum(f)
+	n, err := io.Copy(dst, r)
 	if err != nil {
-		return err
+		return partialEntry(name, err)
 	}
 	w.Size += uint64(n)
-	log.Printf("File <%s> was added.", name)
+	w.record(name, n, header.Modified, h)
+	w.added(name, n)
 	return nil
 }
 
-// Close function closes the active IO handles and relocates the file.
+// added reports a file that was stored in the archive.
+func (w *SaneWriter) added(name string, size int64) {
+	w.Progress.addFile()
+	slog.Debug(`File was added`, `path`, name, `size`, size)
+	w.Events.emit(FileAdded{Path: name, Size: size})
+}
+
+// Close function finishes the archive and flushes the active IO handles.
 func (w *SaneWriter) Close() error {
 	if !w.headerReady {
-		log.Fatalf(`No files were added to the archive!`)
+		return ErrEmptyArchive
+	}
+	if w.Manifest != nil {
+		if err := w.writeManifest(); err != nil {
+			return fmt.Errorf(`could not write the manifest: %w`, err)
+		}
+	}
+	if err := w.flushBeforeClose(); err != nil {
+		return err
+	}
+	if w.Format == FormatTar {
+		if err := w.tarHandle.Close(); err != nil {
+			return err
+		}
+		if err := w.compressHandle.Close(); err != nil {
+			return err
+		}
+	} else if err := w.archiveHandle.Close(); err != nil {
+		return err
 	}
-	w.archiveHandle.Close()
 	return w.cipherHandle.Close()
 }